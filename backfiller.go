@@ -91,8 +91,11 @@ func (rd *RepoDownloader) getRateLimiter(service string) ratelimit.Limiter {
 	return limiter
 }
 
-func (rd *RepoDownloader) downloadRepo(service, did string) ([]byte, error) {
+func (rd *RepoDownloader) downloadRepo(service, did, since string) ([]byte, error) {
 	dlurl := fmt.Sprintf("%s/xrpc/com.atproto.sync.getRepo?did=%s", service, did)
+	if since != "" {
+		dlurl += "&since=" + since
+	}
 
 	req, err := http.NewRequestWithContext(context.TODO(), "GET", dlurl, nil)
 	if err != nil {
@@ -148,6 +151,8 @@ func (p *Photocopy) processRepo(ctx context.Context, b []byte, did string) error
 		return nil
 	}
 
+	rev := r.SignedCommit().Rev
+
 	if err := r.ForEach(context.TODO(), "", func(key string, cid cid.Cid) error {
 		pts := strings.Split(key, "/")
 		nsid := pts[0]
@@ -157,7 +162,7 @@ func (p *Photocopy) processRepo(ctx context.Context, b []byte, did string) error
 		if err != nil {
 			return nil
 		}
-		if err := p.handleCreate(ctx, b.RawData(), time.Now().Format(time.RFC3339Nano), "unk", did, nsid, rkey, cidStr, "unk"); err != nil {
+		if err := p.handleCreate(ctx, b.RawData(), time.Now().Format(time.RFC3339Nano), rev, did, nsid, rkey, cidStr, "unk"); err != nil {
 			return err
 		}
 		return nil
@@ -395,7 +400,7 @@ func (p *Photocopy) runBackfiller(ctx context.Context) error {
 				ratelimiter := downloader.getRateLimiter(service)
 				ratelimiter.Take()
 
-				b, err := downloader.downloadRepo(service, did)
+				b, err := downloader.downloadRepo(service, did, "")
 				if err != nil {
 					errored++
 					processed++
@@ -0,0 +1,144 @@
+package photocopy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type CaptureArgs struct {
+	Logger        *slog.Logger
+	RelayHost     string
+	Dir           string
+	SegmentFrames int
+}
+
+// Capture writes raw firehose frames from the relay to segment files in args.Dir until the context is
+// cancelled. It picks up from the last captured seq when restarted.
+func Capture(ctx context.Context, args CaptureArgs) error {
+	if args.Logger == nil {
+		args.Logger = slog.Default()
+	}
+	if args.SegmentFrames <= 0 {
+		args.SegmentFrames = 100_000
+	}
+	logger := args.Logger
+
+	w, err := newSegmentWriter(args.Dir, args.SegmentFrames)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := w.close(); err != nil {
+			logger.Error("failed to close segment", "error", err)
+		}
+	}()
+
+	var cursor int64
+	segs, err := listSegments(args.Dir)
+	if err != nil {
+		return err
+	}
+	if len(segs) > 0 {
+		cursor = segs[len(segs)-1].lastSeq
+	}
+
+	u, err := url.Parse(args.RelayHost)
+	if err != nil {
+		return err
+	}
+	u.Path = "/xrpc/com.atproto.sync.subscribeRepos"
+
+	attempt := 0
+	for {
+		if cursor != 0 {
+			u.RawQuery = "cursor=" + strconv.FormatInt(cursor, 10)
+		}
+
+		logger.Info("connecting to relay for capture", "url", u.String())
+
+		connectedAt := time.Now()
+		err := captureStream(ctx, logger, u, w, &cursor)
+		if ctx.Err() != nil {
+			logger.Info("capture shut down", "seq", cursor)
+			return nil
+		}
+
+		if time.Since(connectedAt) >= stableConnectionTime {
+			attempt = 0
+		}
+
+		wait := reconnectBackoff(attempt)
+		attempt++
+
+		logger.Warn("capture connection lost, reconnecting", "error", err, "attempt", attempt, "wait", wait)
+
+		select {
+		case <-ctx.Done():
+			logger.Info("capture shut down", "seq", cursor)
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+func captureStream(ctx context.Context, logger *slog.Logger, u *url.URL, w *segmentWriter, cursor *int64) error {
+	con, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), http.Header{
+		"user-agent": []string{"photocopy/0.0.0"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to relay: %w", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		con.Close()
+	}()
+
+	for {
+		if err := con.SetReadDeadline(time.Now().Add(time.Minute)); err != nil {
+			return err
+		}
+
+		mt, frame, err := con.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("con err at read: %w", err)
+		}
+
+		if mt != websocket.BinaryMessage {
+			return fmt.Errorf("expected binary message from subscription endpoint")
+		}
+
+		// only the seq is needed to store a frame. failing here would reconnect at the same cursor
+		// and get the same frame back forever, so a frame we can't read is kept under the last seq
+		// we saw and left for replay to skip
+		seq, err := frameSeq(frame)
+		if err != nil {
+			logger.Warn("failed to read seq from frame, storing it with the previous seq", "seq", *cursor, "error", err)
+			captureUnreadableFrames.Inc()
+			seq = *cursor
+		}
+
+		// frames without a seq can't be addressed on replay, so there's no point keeping them
+		if seq == 0 {
+			continue
+		}
+
+		if err := w.write(seq, frame); err != nil {
+			return fmt.Errorf("failed to write frame: %w", err)
+		}
+		*cursor = seq
+	}
+}
@@ -0,0 +1,274 @@
+package photocopy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/haileyok/photocopy/clickhouse_inserter"
+)
+
+const (
+	cascadeInterval = 30 * time.Second
+	cascadeChunk    = 1000
+	// on top of the flush interval and retry backoff, time for batches queued behind others and for the
+	// sends themselves
+	insertSettleSlack = time.Minute
+)
+
+type cascadeTarget struct {
+	table  string
+	column string
+}
+
+// the typed tables a delete for each collection cascades into, and the column the rows are keyed by
+var cascadeTargets = map[string][]cascadeTarget{
+	"app.bsky.feed.post":         {{"post", "uri"}, {"post_facet", "uri"}, {"post_media", "uri"}},
+	"app.bsky.feed.like":         {{"interaction", "uri"}},
+	"app.bsky.feed.repost":       {{"interaction", "uri"}},
+	"app.bsky.feed.threadgate":   {{"threadgate", "uri"}},
+	"app.bsky.feed.postgate":     {{"postgate", "uri"}},
+	"app.bsky.feed.generator":    {{"feed_generator", "uri"}},
+	"app.bsky.graph.follow":      {{"follow", "uri"}},
+	"app.bsky.graph.block":       {{"block", "uri"}},
+	"app.bsky.graph.list":        {{"list", "uri"}},
+	"app.bsky.graph.listitem":    {{"list_item", "uri"}},
+	"app.bsky.graph.listblock":   {{"list_block", "uri"}},
+	"app.bsky.graph.starterpack": {{"starter_pack", "uri"}},
+	"app.bsky.labeler.service":   {{"labeler_service", "uri"}},
+	"app.bsky.actor.profile":     {{"profile", "did"}},
+}
+
+type cascadeDelete struct {
+	Table  string    `json:"table"`
+	Column string    `json:"column"`
+	Value  string    `json:"value"`
+	Rev    string    `json:"rev"`
+	At     time.Time `json:"at"`
+}
+
+// cascader batches up the deletes that cascade into the typed tables. the queue is written to disk
+// before each cursor save, so a delete behind the saved cursor is never lost to a restart.
+type cascader struct {
+	mu     sync.Mutex
+	queued []cascadeDelete
+	// set when queued has changed since it was last written to file
+	dirty bool
+	// rows for a record can still be sitting in an inserter batch when its delete arrives, so deletes
+	// wait until any batch that was pending at the time has either landed or been dead lettered
+	delay time.Duration
+	file  string
+}
+
+// insertSettleDelay is how long to wait before any row that is sitting in an inserter batch now has
+// either landed or been dead lettered. without a flush interval a partial batch can sit indefinitely, so
+// there's no delay that would be safe.
+func insertSettleDelay(flushInterval time.Duration, maxRetries int, retryBackoff time.Duration) (time.Duration, error) {
+	if flushInterval <= 0 {
+		return 0, fmt.Errorf("an insert flush interval is required, otherwise rows can stay in a partial batch indefinitely")
+	}
+
+	return flushInterval + clickhouse_inserter.MaxRetryDelay(maxRetries, retryBackoff) + insertSettleSlack, nil
+}
+
+func newCascader(file string, flushInterval time.Duration, maxRetries int, retryBackoff time.Duration) (*cascader, error) {
+	delay, err := insertSettleDelay(flushInterval, maxRetries, retryBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("delete cascade: %w", err)
+	}
+
+	c := &cascader{
+		delay: delay,
+		file:  file,
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &c.queued); err != nil {
+			return nil, fmt.Errorf("invalid pending cascades in %s: %w", file, err)
+		}
+	}
+	cascadeQueued.Set(float64(len(c.queued)))
+
+	return c, nil
+}
+
+// save writes the queue to disk if it has changed since the last save.
+func (c *cascader) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	b, err := json.Marshal(c.queued)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.file, b, 0644); err != nil {
+		return err
+	}
+	c.dirty = false
+
+	return nil
+}
+
+func (p *Photocopy) saveCascades() {
+	if p.cascader == nil {
+		return
+	}
+
+	if err := p.cascader.save(); err != nil {
+		p.logger.Error("failed to save pending cascades", "file", p.cascader.file, "error", err)
+	}
+}
+
+func (p *Photocopy) enqueueCascade(did, collection, rkey, rev string) {
+	if p.cascader == nil || !p.typedFilter.Allow(did, collection) {
+		return
+	}
+
+	// the rev is what keeps a delete from taking out a later recreation of the same record, so without
+	// one it's not safe to cascade
+	if rev == "" {
+		return
+	}
+
+	targets, ok := cascadeTargets[collection]
+	if !ok {
+		return
+	}
+
+	uri := uriFromParts(did, collection, rkey)
+	now := time.Now()
+
+	p.cascader.mu.Lock()
+	defer p.cascader.mu.Unlock()
+	for _, t := range targets {
+		value := uri
+		if t.column == "did" {
+			value = did
+		}
+		p.cascader.queued = append(p.cascader.queued, cascadeDelete{Table: t.table, Column: t.column, Value: value, Rev: rev, At: now})
+	}
+	p.cascader.dirty = true
+	cascadeQueued.Set(float64(len(p.cascader.queued)))
+}
+
+func (p *Photocopy) runCascader(ctx context.Context) {
+	if p.cascader == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(cascadeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.flushCascades(ctx, false)
+			}
+		}
+	}()
+}
+
+// closeCascader runs whatever deletes are still queued. it should be called after the inserters are
+// closed so the rows being deleted have actually been written. anything that fails stays on disk for
+// the next start.
+func (p *Photocopy) closeCascader() {
+	if p.cascader == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p.flushCascades(ctx, true)
+	p.saveCascades()
+}
+
+func (p *Photocopy) flushCascades(ctx context.Context, force bool) {
+	cutoff := time.Now().Add(-p.cascader.delay)
+
+	p.cascader.mu.Lock()
+	var ready []cascadeDelete
+	remaining := p.cascader.queued[:0]
+	for _, cd := range p.cascader.queued {
+		if force || cd.At.Before(cutoff) {
+			ready = append(ready, cd)
+		} else {
+			remaining = append(remaining, cd)
+		}
+	}
+	p.cascader.queued = remaining
+	if len(ready) > 0 {
+		p.cascader.dirty = true
+	}
+	cascadeQueued.Set(float64(len(remaining)))
+	p.cascader.mu.Unlock()
+
+	if len(ready) == 0 {
+		return
+	}
+
+	// a record deleted more than once in the window only needs its latest delete
+	byTarget := make(map[cascadeTarget]map[string]string)
+	for _, cd := range ready {
+		t := cascadeTarget{cd.Table, cd.Column}
+		revs, ok := byTarget[t]
+		if !ok {
+			revs = make(map[string]string)
+			byTarget[t] = revs
+		}
+		if cd.Rev > revs[cd.Value] {
+			revs[cd.Value] = cd.Rev
+		}
+	}
+
+	var failed []cascadeDelete
+	now := time.Now()
+	for t, revs := range byTarget {
+		keys := slices.Sorted(maps.Keys(revs))
+		for chunk := range slices.Chunk(keys, cascadeChunk) {
+			chunkRevs := make([]string, 0, len(chunk))
+			for _, k := range chunk {
+				chunkRevs = append(chunkRevs, revs[k])
+			}
+
+			// only rows written at or before the delete's rev go, so a record recreated under the same
+			// key afterwards survives. revs are tids, which sort the same as strings as they do in time
+			query := fmt.Sprintf("DELETE FROM %s WHERE has(?, %s) AND rev <= arrayElement(?, indexOf(?, %s))", t.table, t.column, t.column)
+			if err := p.conn.Exec(ctx, query, chunk, chunkRevs, chunk); err != nil {
+				// deletes are idempotent, so the chunk goes back on the queue to be retried after another delay
+				p.logger.Error("failed to cascade deletes", "table", t.table, "count", len(chunk), "error", err)
+				cascadeDeletes.WithLabelValues(t.table, "failed").Add(float64(len(chunk)))
+				for i, k := range chunk {
+					failed = append(failed, cascadeDelete{Table: t.table, Column: t.column, Value: k, Rev: chunkRevs[i], At: now})
+				}
+				continue
+			}
+			cascadeDeletes.WithLabelValues(t.table, "deleted").Add(float64(len(chunk)))
+		}
+	}
+
+	if len(failed) > 0 {
+		p.cascader.mu.Lock()
+		p.cascader.queued = append(p.cascader.queued, failed...)
+		cascadeQueued.Set(float64(len(p.cascader.queued)))
+		p.cascader.mu.Unlock()
+	}
+
+	p.saveCascades()
+}
@@ -0,0 +1,181 @@
+package photocopy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type execCall struct {
+	query string
+	args  []any
+}
+
+type recordingConn struct {
+	driver.Conn
+	execs   []execCall
+	failing bool
+}
+
+func (c *recordingConn) Exec(ctx context.Context, query string, args ...any) error {
+	c.execs = append(c.execs, execCall{query: query, args: args})
+	if c.failing {
+		return errors.New("clickhouse is down")
+	}
+	return nil
+}
+
+func TestNewCascader(t *testing.T) {
+	tests := []struct {
+		name          string
+		flushInterval time.Duration
+		maxRetries    int
+		retryBackoff  time.Duration
+		wantDelay     time.Duration
+		wantErr       bool
+	}{
+		{
+			name:          "no flush interval is refused",
+			flushInterval: 0,
+			wantErr:       true,
+		},
+		{
+			name:          "no retries",
+			flushInterval: 10 * time.Second,
+			wantDelay:     10*time.Second + insertSettleSlack,
+		},
+		{
+			name:          "retry backoff doubles up to the cap",
+			flushInterval: 10 * time.Second,
+			maxRetries:    5,
+			retryBackoff:  10 * time.Second,
+			// 10s, 20s, 40s, then capped at a minute twice
+			wantDelay: 10*time.Second + 190*time.Second + insertSettleSlack,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := newCascader(filepath.Join(t.TempDir(), "cursor.cascades"), tt.flushInterval, tt.maxRetries, tt.retryBackoff)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newCascader err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && c.delay != tt.wantDelay {
+				t.Errorf("delay = %s, want %s", c.delay, tt.wantDelay)
+			}
+		})
+	}
+}
+
+func TestFlushCascadesBoundsByRev(t *testing.T) {
+	conn := &recordingConn{}
+	p := &Photocopy{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		conn:     conn,
+		cascader: &cascader{delay: time.Hour, file: filepath.Join(t.TempDir(), "cursor.cascades")},
+	}
+
+	p.enqueueCascade("did:plc:a", "app.bsky.graph.follow", "3kaaa", "3kaab")
+	// deleted again after being recreated, only the later rev should be used
+	p.enqueueCascade("did:plc:a", "app.bsky.graph.follow", "3kaaa", "3kzzz")
+	p.enqueueCascade("did:plc:b", "app.bsky.graph.follow", "3kbbb", "3kbbc")
+	// no rev to bound the delete by
+	p.enqueueCascade("did:plc:c", "app.bsky.graph.follow", "3kccc", "")
+	// not a collection with a typed table
+	p.enqueueCascade("did:plc:d", "app.bsky.feed.unknown", "3kddd", "3kdde")
+
+	p.flushCascades(context.Background(), false)
+	if len(conn.execs) != 0 {
+		t.Fatalf("deletes ran before the delay: %v", conn.execs)
+	}
+
+	p.flushCascades(context.Background(), true)
+	if len(conn.execs) != 1 {
+		t.Fatalf("got %d deletes, want 1", len(conn.execs))
+	}
+
+	call := conn.execs[0]
+	if !strings.HasPrefix(call.query, "DELETE FROM follow WHERE has(?, uri) AND rev <= ") {
+		t.Errorf("query = %q", call.query)
+	}
+
+	wantKeys := []string{"at://did:plc:a/app.bsky.graph.follow/3kaaa", "at://did:plc:b/app.bsky.graph.follow/3kbbb"}
+	wantRevs := []string{"3kzzz", "3kbbc"}
+	if len(call.args) != 3 {
+		t.Fatalf("got %d args, want 3", len(call.args))
+	}
+	if keys := call.args[0].([]string); !slices.Equal(keys, wantKeys) {
+		t.Errorf("keys = %v, want %v", keys, wantKeys)
+	}
+	if revs := call.args[1].([]string); !slices.Equal(revs, wantRevs) {
+		t.Errorf("revs = %v, want %v", revs, wantRevs)
+	}
+
+	if len(p.cascader.queued) != 0 {
+		t.Errorf("%d deletes still queued", len(p.cascader.queued))
+	}
+}
+
+func TestFlushCascadesRequeuesFailures(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cursor.cascades")
+	c, err := newCascader(file, 10*time.Second, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := &recordingConn{failing: true}
+	p := &Photocopy{
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		conn:     conn,
+		cascader: c,
+	}
+
+	p.enqueueCascade("did:plc:a", "app.bsky.graph.follow", "3kaaa", "3kaab")
+	p.enqueueCascade("did:plc:a", "app.bsky.graph.follow", "3kaaa", "3kzzz")
+
+	// queued deletes survive a restart
+	p.saveCascades()
+	restarted, err := newCascader(file, 10*time.Second, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.queued) != 2 {
+		t.Fatalf("reloaded %d deletes, want 2", len(restarted.queued))
+	}
+	p.cascader = restarted
+
+	// a failed chunk goes back on the queue, collapsed to its latest rev, and is written back to disk
+	p.flushCascades(context.Background(), true)
+	if len(conn.execs) != 1 {
+		t.Fatalf("got %d deletes, want 1", len(conn.execs))
+	}
+
+	reloaded, err := newCascader(file, 10*time.Second, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pc := range []*cascader{p.cascader, reloaded} {
+		if len(pc.queued) != 1 {
+			t.Fatalf("%d deletes queued, want 1", len(pc.queued))
+		}
+		cd := pc.queued[0]
+		if cd.Table != "follow" || cd.Value != "at://did:plc:a/app.bsky.graph.follow/3kaaa" || cd.Rev != "3kzzz" {
+			t.Errorf("queued %+v", cd)
+		}
+	}
+
+	// and runs once clickhouse is back
+	conn.failing = false
+	p.flushCascades(context.Background(), true)
+	if len(p.cascader.queued) != 0 {
+		t.Errorf("%d deletes still queued", len(p.cascader.queued))
+	}
+}
@@ -0,0 +1,344 @@
+package clickhouse_inserter
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+// dead letter files are jsonl. the first line is a header naming the insert query the rows were meant
+// for, and every line after it is one row keyed by column name. json strings can't hold arbitrary bytes,
+// so columns carrying binary data (raw dag-cbor, car blocks) are written as base64 and listed in the
+// header so replay can decode them.
+const deadLetterExt = ".jsonl"
+
+type deadLetterHeader struct {
+	Query  string    `json:"query"`
+	Prefix string    `json:"prefix"`
+	Rows   int       `json:"rows"`
+	Error  string    `json:"error"`
+	Time   time.Time `json:"time"`
+	Base64 []string  `json:"base64,omitempty"`
+}
+
+func writeDeadLetter(dir, prefix, query string, rows []any, sendErr error) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	name := prefix
+	if name == "" {
+		name = "inserter"
+	}
+
+	// timestamped so that replays go in roughly the order the batches failed
+	tmp, err := os.CreateTemp(dir, fmt.Sprintf("%s-%d-*.tmp", name, time.Now().UnixNano()))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+
+	cols := make([]map[string]any, 0, len(rows))
+	for _, row := range rows {
+		cols = append(cols, rowColumns(row))
+	}
+
+	hdr := deadLetterHeader{
+		Query:  query,
+		Prefix: prefix,
+		Rows:   len(rows),
+		Time:   time.Now(),
+		Base64: binaryColumns(cols),
+	}
+	if sendErr != nil {
+		hdr.Error = sendErr.Error()
+	}
+
+	if err := enc.Encode(hdr); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	for _, c := range cols {
+		for _, name := range hdr.Base64 {
+			// []byte is already base64 encoded by encoding/json
+			if v, ok := c[name].(string); ok {
+				c[name] = base64.StdEncoding.EncodeToString([]byte(v))
+			}
+		}
+
+		if err := enc.Encode(c); err != nil {
+			tmp.Close()
+			return "", err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := strings.TrimSuffix(tmp.Name(), ".tmp") + deadLetterExt
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// rowColumns maps a struct to its ch tagged columns, so that the row can be inserted with JSONEachRow
+func rowColumns(row any) map[string]any {
+	v := reflect.ValueOf(row)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	cols := make(map[string]any)
+	if v.Kind() != reflect.Struct {
+		return cols
+	}
+
+	t := v.Type()
+	for idx := 0; idx < t.NumField(); idx++ {
+		f := t.Field(idx)
+		if !f.IsExported() {
+			continue
+		}
+
+		name := f.Tag.Get("ch")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		cols[name] = v.Field(idx).Interface()
+	}
+
+	return cols
+}
+
+// binaryColumns returns the columns that can't be written as json strings as-is. a column is binary for
+// the whole file if any row has bytes in it that aren't valid utf-8, since encoding/json would replace
+// them.
+func binaryColumns(cols []map[string]any) []string {
+	var binary []string
+	for _, c := range cols {
+		for name, v := range c {
+			if slices.Contains(binary, name) {
+				continue
+			}
+
+			switch v := v.(type) {
+			case string:
+				if !utf8.ValidString(v) {
+					binary = append(binary, name)
+				}
+			case []byte:
+				binary = append(binary, name)
+			}
+		}
+	}
+	slices.Sort(binary)
+
+	return binary
+}
+
+// ReplayDeadLetters re-inserts every dead letter file in dir, removing each one once all of its rows
+// have been inserted. Files that fail are left in place to try again later.
+func ReplayDeadLetters(ctx context.Context, conn driver.Conn, dir string, logger *slog.Logger) error {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+deadLetterExt))
+	if err != nil {
+		return err
+	}
+	slices.Sort(paths)
+
+	var failed int
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rows, err := replayDeadLetter(ctx, conn, path)
+		if err != nil {
+			logger.Error("failed to replay dead letter file", "path", path, "error", err)
+			failed++
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+
+		logger.Info("replayed dead letter file", "path", path, "rows", rows)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letter files failed to replay", failed, len(paths))
+	}
+
+	return nil
+}
+
+func replayDeadLetter(ctx context.Context, conn driver.Conn, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("missing header")
+	}
+
+	var hdr deadLetterHeader
+	if err := json.Unmarshal(sc.Bytes(), &hdr); err != nil {
+		return 0, fmt.Errorf("invalid header: %w", err)
+	}
+
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(hdr.Query)), "INSERT INTO") {
+		return 0, fmt.Errorf("header query is not an insert: %q", hdr.Query)
+	}
+
+	// rows were written with go's json encoding, so times need best effort parsing and columns that
+	// have since been dropped are skipped
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(clickhouse.Settings{
+		"input_format_skip_unknown_fields": 1,
+		"date_time_input_format":           "best_effort",
+	}))
+
+	// files hold a single batch, so they go back in as one insert and a failure never leaves part of a
+	// file inserted
+	var rows []string
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		rows = append(rows, line)
+	}
+
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	query := hdr.Query
+	if len(hdr.Base64) > 0 {
+		query, err = base64InsertQuery(ctx, conn, hdr.Query, hdr.Base64)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	query += " FORMAT JSONEachRow\n" + strings.Join(rows, "\n")
+	if err := conn.Exec(ctx, query); err != nil {
+		return 0, err
+	}
+
+	return len(rows), nil
+}
+
+var insertQueryRe = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+([^\s(]+)\s*\((.*)\)\s*$`)
+
+// base64InsertQuery rewrites an insert so the rows are read through input() and the base64 columns are
+// decoded with unbase64 on the way in. input() needs the column types, which are looked up from the
+// table, except for the base64 columns which arrive as plain strings. columns the table no longer has
+// are dropped from the insert.
+func base64InsertQuery(ctx context.Context, conn driver.Conn, query string, base64Cols []string) (string, error) {
+	m := insertQueryRe.FindStringSubmatch(query)
+	if m == nil {
+		return "", fmt.Errorf("dead letter has base64 columns but the query has no column list: %q", query)
+	}
+	table := m[1]
+
+	var columns []string
+	for _, c := range strings.Split(m[2], ",") {
+		columns = append(columns, strings.Trim(strings.TrimSpace(c), "`"))
+	}
+
+	db, name, ok := strings.Cut(strings.ReplaceAll(table, "`", ""), ".")
+	if !ok {
+		db, name = "", db
+	}
+
+	var tableCols []struct {
+		Name string `ch:"name"`
+		Type string `ch:"type"`
+	}
+	if err := conn.Select(ctx, &tableCols, "SELECT name, type FROM system.columns WHERE database = if(? = '', currentDatabase(), ?) AND table = ?", db, db, name); err != nil {
+		return "", fmt.Errorf("failed to look up columns for %s: %w", table, err)
+	}
+
+	types := make(map[string]string, len(tableCols))
+	for _, c := range tableCols {
+		types[c.Name] = c.Type
+	}
+
+	// columns that have since been dropped from the table are left out, and input_format_skip_unknown_fields
+	// skips them in the rows just like a plain JSONEachRow insert does
+	kept := make([]string, 0, len(columns))
+	structure := make([]string, 0, len(columns))
+	selects := make([]string, 0, len(columns))
+	for _, c := range columns {
+		typ, ok := types[c]
+		if !ok {
+			continue
+		}
+		kept = append(kept, fmt.Sprintf("`%s`", c))
+
+		if slices.Contains(base64Cols, c) {
+			structure = append(structure, fmt.Sprintf("`%s` String", c))
+			selects = append(selects, fmt.Sprintf("unbase64(`%s`) AS `%s`", c, c))
+			continue
+		}
+
+		structure = append(structure, fmt.Sprintf("`%s` %s", c, typ))
+		selects = append(selects, fmt.Sprintf("`%s`", c))
+	}
+
+	if len(kept) == 0 {
+		return "", fmt.Errorf("none of the dead letter columns exist in %s anymore", table)
+	}
+
+	quoted := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(strings.Join(structure, ", "))
+
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM input('%s')", table, strings.Join(kept, ", "), strings.Join(selects, ", "), quoted), nil
+}
@@ -0,0 +1,176 @@
+package clickhouse_inserter
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/haileyok/photocopy/models"
+)
+
+// fakeConn records the statements replay sends and answers the column lookup for the record table
+type fakeConn struct {
+	driver.Conn
+
+	selects int
+	execs   []string
+	// columns to leave out of the table, as if they had been dropped since the rows were written
+	dropped []string
+}
+
+func (c *fakeConn) Select(ctx context.Context, dest any, query string, args ...any) error {
+	c.selects++
+
+	cols := map[string]string{
+		"did":        "String",
+		"rkey":       "String",
+		"collection": "LowCardinality(String)",
+		"cid":        "String",
+		"seq":        "String",
+		"rev":        "String",
+		"raw":        "String",
+		"json":       "String",
+		"created_at": "DateTime64(3)",
+	}
+
+	v := reflect.ValueOf(dest).Elem()
+	for name, typ := range cols {
+		if slices.Contains(c.dropped, name) {
+			continue
+		}
+		row := reflect.New(v.Type().Elem()).Elem()
+		row.FieldByName("Name").SetString(name)
+		row.FieldByName("Type").SetString(typ)
+		v.Set(reflect.Append(v, row))
+	}
+
+	return nil
+}
+
+func (c *fakeConn) Exec(ctx context.Context, query string, args ...any) error {
+	c.execs = append(c.execs, query)
+	return nil
+}
+
+func TestDeadLetterRoundTrip(t *testing.T) {
+	const query = "INSERT INTO record (did, rkey, collection, cid, seq, rev, raw, created_at)"
+
+	binary := string([]byte{0xa2, 0x65, 0x24, 0xff, 0x00, 0xc3, 0x28, '"', '\\', '\n'})
+
+	tests := []struct {
+		name       string
+		raws       []string
+		dropped    []string
+		wantBase64 bool
+	}{
+		{
+			name:       "non utf-8 raw is base64 encoded and decoded on replay",
+			raws:       []string{binary, "plain"},
+			wantBase64: true,
+		},
+		{
+			name:       "columns dropped from the table are left out of the insert",
+			raws:       []string{binary},
+			dropped:    []string{"cid"},
+			wantBase64: true,
+		},
+		{
+			name: "valid utf-8 rows are inserted as-is",
+			raws: []string{"plain", "also plain ✓"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			var rows []any
+			for i, raw := range tt.raws {
+				rows = append(rows, models.Record{
+					Did:        "did:plc:example",
+					Rkey:       "3kabc",
+					Collection: "app.bsky.feed.post",
+					Seq:        string(rune('0' + i)),
+					Raw:        raw,
+					CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+				})
+			}
+
+			if _, err := writeDeadLetter(dir, "photocopy_records", query, rows, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			conn := &fakeConn{dropped: tt.dropped}
+			if err := ReplayDeadLetters(context.Background(), conn, dir, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(conn.execs) != 1 {
+				t.Fatalf("got %d inserts, want 1", len(conn.execs))
+			}
+
+			stmt, body, ok := strings.Cut(conn.execs[0], " FORMAT JSONEachRow\n")
+			if !ok {
+				t.Fatalf("insert is missing its rows: %q", conn.execs[0])
+			}
+
+			if tt.wantBase64 {
+				if !strings.Contains(stmt, "unbase64(`raw`) AS `raw`") || !strings.Contains(stmt, "`raw` String") {
+					t.Errorf("insert doesn't decode raw: %q", stmt)
+				}
+				if !strings.Contains(stmt, "`created_at` DateTime64(3)") {
+					t.Errorf("insert doesn't carry the table's column types: %q", stmt)
+				}
+				for _, c := range tt.dropped {
+					if strings.Contains(stmt, "`"+c+"`") {
+						t.Errorf("insert still names dropped column %s: %q", c, stmt)
+					}
+				}
+			} else {
+				if stmt != query {
+					t.Errorf("insert = %q, want %q", stmt, query)
+				}
+				if conn.selects != 0 {
+					t.Errorf("looked up columns without any base64 columns")
+				}
+			}
+
+			lines := strings.Split(body, "\n")
+			if len(lines) != len(tt.raws) {
+				t.Fatalf("got %d rows, want %d", len(lines), len(tt.raws))
+			}
+
+			for i, line := range lines {
+				var row map[string]any
+				if err := json.Unmarshal([]byte(line), &row); err != nil {
+					t.Fatal(err)
+				}
+
+				got, _ := row["raw"].(string)
+				if tt.wantBase64 {
+					b, err := base64.StdEncoding.DecodeString(got)
+					if err != nil {
+						t.Fatalf("row %d raw is not base64: %v", i, err)
+					}
+					got = string(b)
+				}
+
+				if got != tt.raws[i] {
+					t.Errorf("row %d raw = %q, want %q", i, got, tt.raws[i])
+				}
+			}
+
+			left, _ := filepath.Glob(filepath.Join(dir, "*"+deadLetterExt))
+			if len(left) != 0 {
+				t.Errorf("dead letter files left after replay: %v", left)
+			}
+		})
+	}
+}
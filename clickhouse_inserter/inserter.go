@@ -2,30 +2,60 @@ package clickhouse_inserter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"reflect"
+	"regexp"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"go.uber.org/ratelimit"
 )
 
+const maxRetryBackoff = time.Minute
+
+var ErrClosed = errors.New("inserter is closed")
+
 type Inserter struct {
 	conn           driver.Conn
 	query          string
 	mu             sync.Mutex
 	queuedEvents   []any
+	oldestQueued   time.Time
 	batchSize      int
 	insertsCounter *prometheus.CounterVec
 	pendingSends   prometheus.Gauge
 	histogram      *prometheus.HistogramVec
+	batchAge       *prometheus.HistogramVec
 	logger         *slog.Logger
 	prefix         string
 	rateLimit      ratelimit.Limiter
+	flushInterval  time.Duration
+	stopFlusher    chan struct{}
+	flusherDone    chan struct{}
+	closeOnce      sync.Once
+
+	maxRetries       int
+	retryBaseBackoff time.Duration
+	retriesCounter   prometheus.Counter
+	deadLetterDir    string
+
+	closed        bool
+	sendQueue     chan []any
+	sendMu        sync.RWMutex
+	sendClosed    bool
+	sendCtx       context.Context
+	cancelSends   context.CancelFunc
+	sendWg        sync.WaitGroup
+	queuedBatches prometheus.Gauge
 }
 
 type Args struct {
@@ -36,6 +66,20 @@ type Args struct {
 	Logger                  *slog.Logger
 	Histogram               *prometheus.HistogramVec
 	RateLimit               int
+	// when set, partial batches are sent on this interval instead of waiting for BatchSize
+	FlushInterval time.Duration
+	// transient send failures are retried this many times with exponential backoff starting at
+	// RetryBackoff
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// batches that still fail are written here as jsonl so they can be replayed with ReplayDeadLetters.
+	// when empty they are dropped
+	DeadLetterDir string
+	// full batches are handed to this many goroutines to send, so callers only block on the network
+	// once SendQueueSize batches are already waiting. SendWorkers defaults to 1 and SendQueueSize to
+	// SendWorkers
+	SendWorkers   int
+	SendQueueSize int
 }
 
 func New(ctx context.Context, args *Args) (*Inserter, error) {
@@ -44,13 +88,35 @@ func New(ctx context.Context, args *Args) (*Inserter, error) {
 	}
 
 	inserter := &Inserter{
-		conn:      args.Conn,
-		query:     args.Query,
-		mu:        sync.Mutex{},
-		batchSize: args.BatchSize,
-		histogram: args.Histogram,
-		logger:    args.Logger,
-		prefix:    args.PrometheusCounterPrefix,
+		conn:          args.Conn,
+		query:         args.Query,
+		mu:            sync.Mutex{},
+		batchSize:     args.BatchSize,
+		histogram:     args.Histogram,
+		logger:        args.Logger,
+		prefix:        args.PrometheusCounterPrefix,
+		flushInterval: args.FlushInterval,
+
+		maxRetries:       args.MaxRetries,
+		retryBaseBackoff: args.RetryBackoff,
+		deadLetterDir:    args.DeadLetterDir,
+	}
+
+	if args.SendWorkers <= 0 {
+		args.SendWorkers = 1
+	}
+
+	if args.SendQueueSize <= 0 {
+		args.SendQueueSize = args.SendWorkers
+	}
+
+	inserter.sendQueue = make(chan []any, args.SendQueueSize)
+	// sends outlive ctx so that batches queued during shutdown still go out. Close cancels them if it
+	// runs out of time.
+	inserter.sendCtx, inserter.cancelSends = context.WithCancel(context.WithoutCancel(ctx))
+
+	if inserter.retryBaseBackoff == 0 {
+		inserter.retryBaseBackoff = time.Second
 	}
 
 	if args.RateLimit != 0 {
@@ -59,62 +125,200 @@ func New(ctx context.Context, args *Args) (*Inserter, error) {
 	}
 
 	if args.PrometheusCounterPrefix != "" {
+		// every inserter's metrics carry the table so dashboards can sum across them and still tell them apart
+		labels := prometheus.Labels{"table": insertTable(args.Query)}
+
 		inserter.insertsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
-			Name:      "clickhouse_inserts",
-			Namespace: args.PrometheusCounterPrefix,
-			Help:      "total inserts into clickhouse by status",
+			Name:        "clickhouse_inserts",
+			Namespace:   args.PrometheusCounterPrefix,
+			ConstLabels: labels,
+			Help:        "total inserts into clickhouse by status",
 		}, []string{"status"})
 
 		inserter.pendingSends = promauto.NewGauge(prometheus.GaugeOpts{
-			Name:      "clickhouse_pending_sends",
-			Namespace: args.PrometheusCounterPrefix,
-			Help:      "total clickhouse insertions that are in progress",
+			Name:        "clickhouse_pending_sends",
+			Namespace:   args.PrometheusCounterPrefix,
+			ConstLabels: labels,
+			Help:        "total clickhouse insertions that are in progress",
+		})
+
+		inserter.queuedBatches = promauto.NewGauge(prometheus.GaugeOpts{
+			Name:        "clickhouse_queued_batches",
+			Namespace:   args.PrometheusCounterPrefix,
+			ConstLabels: labels,
+			Help:        "full batches waiting for a send worker",
+		})
+
+		inserter.retriesCounter = promauto.NewCounter(prometheus.CounterOpts{
+			Name:        "clickhouse_retries",
+			Namespace:   args.PrometheusCounterPrefix,
+			ConstLabels: labels,
+			Help:        "total batch sends that were retried after a transient error",
 		})
 
+		inserter.batchAge = promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "clickhouse_batch_age_seconds",
+			Namespace:   args.PrometheusCounterPrefix,
+			ConstLabels: labels,
+			Help:        "age of the oldest event in a batch when it was flushed, by what triggered the flush",
+			Buckets:     prometheus.ExponentialBucketsRange(0.01, 3600, 20),
+		}, []string{"trigger"})
+
 	} else {
 		args.Logger.Info("no prometheus prefix provided, no metrics will be registered for this counter", "query", args.Query)
 	}
 
+	for range args.SendWorkers {
+		inserter.sendWg.Add(1)
+		go inserter.runSender()
+	}
+
+	if inserter.flushInterval > 0 {
+		inserter.stopFlusher = make(chan struct{})
+		inserter.flusherDone = make(chan struct{})
+		go inserter.runFlusher()
+	}
+
 	return inserter, nil
 }
 
+func (i *Inserter) runSender() {
+	defer i.sendWg.Done()
+
+	for toInsert := range i.sendQueue {
+		if i.queuedBatches != nil {
+			i.queuedBatches.Dec()
+		}
+		i.sendStream(i.sendCtx, toInsert)
+	}
+}
+
+// enqueue hands a batch to the send workers, blocking while the send queue is full
+func (i *Inserter) enqueue(toInsert []any) {
+	i.sendMu.RLock()
+	defer i.sendMu.RUnlock()
+
+	// a batch taken just as Close shut the queue still has to go somewhere
+	if i.sendClosed {
+		i.sendStream(i.sendCtx, toInsert)
+		return
+	}
+
+	if i.queuedBatches != nil {
+		i.queuedBatches.Inc()
+	}
+	i.sendQueue <- toInsert
+}
+
+func (i *Inserter) runFlusher() {
+	defer close(i.flusherDone)
+
+	ticker := time.NewTicker(i.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-i.stopFlusher:
+			return
+		case <-ticker.C:
+			if toInsert := i.takeQueued("interval"); len(toInsert) > 0 {
+				i.enqueue(toInsert)
+			}
+		}
+	}
+}
+
+func (i *Inserter) takeQueued(trigger string) []any {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.takeQueuedLocked(trigger)
+}
+
+// takeQueuedLocked empties the queue and records the age of the batch under trigger. i.mu must be held.
+func (i *Inserter) takeQueuedLocked(trigger string) []any {
+	if len(i.queuedEvents) == 0 {
+		return nil
+	}
+
+	if i.batchAge != nil {
+		i.batchAge.WithLabelValues(trigger).Observe(time.Since(i.oldestQueued).Seconds())
+	}
+
+	toInsert := slices.Clone(i.queuedEvents)
+	i.queuedEvents = nil
+
+	return toInsert
+}
+
 func (i *Inserter) Insert(ctx context.Context, e any) error {
 	i.mu.Lock()
 
+	if i.closed {
+		i.mu.Unlock()
+		return ErrClosed
+	}
+
+	if len(i.queuedEvents) == 0 {
+		i.oldestQueued = time.Now()
+	}
 	i.queuedEvents = append(i.queuedEvents, e)
 
 	var toInsert []any
 	if len(i.queuedEvents) >= i.batchSize {
-		toInsert = slices.Clone(i.queuedEvents)
-		i.queuedEvents = nil
+		toInsert = i.takeQueuedLocked("size")
 	}
 
 	i.mu.Unlock()
 
 	if len(toInsert) > 0 {
-		i.sendStream(ctx, toInsert)
+		i.enqueue(toInsert)
 	}
 
 	return nil
 }
 
+// Close sends whatever is queued and waits for every in flight send to finish. If ctx is done first,
+// the remaining sends are cancelled, which dead letters their batches.
 func (i *Inserter) Close(ctx context.Context) error {
-	i.mu.Lock()
-
-	var toInsert []any
-
-	if len(i.queuedEvents) > 0 {
-		toInsert = slices.Clone(i.queuedEvents)
-		i.queuedEvents = nil
+	if i.stopFlusher != nil {
+		i.closeOnce.Do(func() {
+			close(i.stopFlusher)
+		})
+		<-i.flusherDone
 	}
 
+	i.mu.Lock()
+	i.closed = true
+	toInsert := i.takeQueuedLocked("close")
 	i.mu.Unlock()
 
 	if len(toInsert) > 0 {
-		i.sendStream(ctx, toInsert)
+		i.enqueue(toInsert)
 	}
 
-	return nil
+	i.sendMu.Lock()
+	if !i.sendClosed {
+		i.sendClosed = true
+		close(i.sendQueue)
+	}
+	i.sendMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		i.sendWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		i.cancelSends()
+		return nil
+	case <-ctx.Done():
+		i.cancelSends()
+		<-done
+		return ctx.Err()
+	}
 }
 
 func (i *Inserter) sendStream(ctx context.Context, toInsert []any) {
@@ -134,20 +338,53 @@ func (i *Inserter) sendStream(ctx context.Context, toInsert []any) {
 		return
 	}
 
-	status := "ok"
-	if i.insertsCounter != nil {
-		defer func() {
-			i.insertsCounter.WithLabelValues(status).Add(float64(len(toInsert)))
-		}()
+	var (
+		rejected []any
+		err      error
+	)
+
+retry:
+	for attempt := 0; ; attempt++ {
+		rejected, err = i.send(ctx, toInsert)
+		if err == nil || attempt >= i.maxRetries || !isTransient(err) {
+			break
+		}
+
+		backoff := i.retryBackoff(attempt)
+		i.logger.Warn("error sending batch, retrying", "prefix", i.prefix, "attempt", attempt+1, "backoff", backoff, "error", err)
+		if i.retriesCounter != nil {
+			i.retriesCounter.Inc()
+		}
+
+		select {
+		case <-ctx.Done():
+			break retry
+		case <-time.After(backoff):
+		}
 	}
 
-	batch, err := i.conn.PrepareBatch(ctx, i.query)
 	if err != nil {
-		i.logger.Error("error creating batch", "prefix", i.prefix, "error", err)
-		status = "failed"
+		i.logger.Error("error sending batch", "prefix", i.prefix, "error", err)
+		i.deadLetter(toInsert, err)
 		return
 	}
 
+	i.countInserts("ok", len(toInsert)-len(rejected))
+
+	if len(rejected) > 0 {
+		i.deadLetter(rejected, fmt.Errorf("rows could not be appended to the batch"))
+	}
+}
+
+// send builds and sends a single batch. rows that can't be appended are skipped and returned, since
+// retrying won't change that.
+func (i *Inserter) send(ctx context.Context, toInsert []any) ([]any, error) {
+	batch, err := i.conn.PrepareBatch(ctx, i.query)
+	if err != nil {
+		return nil, fmt.Errorf("error creating batch: %w", err)
+	}
+
+	var rejected []any
 	for _, d := range toInsert {
 		var structPtr any
 		if reflect.TypeOf(d).Kind() == reflect.Ptr {
@@ -165,6 +402,7 @@ func (i *Inserter) sendStream(ctx context.Context, toInsert []any) {
 
 		if err := batch.AppendStruct(structPtr); err != nil {
 			i.logger.Error("error appending to batch", "prefix", i.prefix, "error", err)
+			rejected = append(rejected, d)
 		}
 	}
 
@@ -173,7 +411,92 @@ func (i *Inserter) sendStream(ctx context.Context, toInsert []any) {
 	}
 
 	if err := batch.Send(); err != nil {
-		status = "failed"
-		i.logger.Error("error sending batch", "prefix", i.prefix, "error", err)
+		return nil, err
+	}
+
+	return rejected, nil
+}
+
+func (i *Inserter) deadLetter(rows []any, sendErr error) {
+	if i.deadLetterDir == "" {
+		i.countInserts("failed", len(rows))
+		return
+	}
+
+	path, err := writeDeadLetter(i.deadLetterDir, i.prefix, i.query, rows, sendErr)
+	if err != nil {
+		i.logger.Error("error writing dead letter file, rows are lost", "prefix", i.prefix, "rows", len(rows), "error", err)
+		i.countInserts("failed", len(rows))
+		return
+	}
+
+	i.logger.Warn("wrote failed rows to dead letter file", "prefix", i.prefix, "rows", len(rows), "path", path)
+	i.countInserts("dead_lettered", len(rows))
+}
+
+func (i *Inserter) countInserts(status string, n int) {
+	if i.insertsCounter != nil && n > 0 {
+		i.insertsCounter.WithLabelValues(status).Add(float64(n))
+	}
+}
+
+func (i *Inserter) retryBackoff(attempt int) time.Duration {
+	d := backoffCeiling(i.retryBaseBackoff, attempt)
+	return d/2 + rand.N(d/2+1)
+}
+
+func backoffCeiling(base time.Duration, attempt int) time.Duration {
+	d := base << attempt
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d
+}
+
+// MaxRetryDelay is the longest a batch can spend backing off between retries with the given settings
+// before it is either inserted or dead lettered, not counting the sends themselves.
+func MaxRetryDelay(maxRetries int, retryBackoff time.Duration) time.Duration {
+	var total time.Duration
+	for attempt := range maxRetries {
+		total += backoffCeiling(retryBackoff, attempt)
+	}
+	return total
+}
+
+// server errors that are worth retrying. anything else from the server, like a bad column or type, will
+// fail the same way every time.
+var transientCodes = map[int32]bool{
+	159: true, // TIMEOUT_EXCEEDED
+	164: true, // READONLY
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	203: true, // NO_FREE_CONNECTION
+	209: true, // SOCKET_TIMEOUT
+	210: true, // NETWORK_ERROR
+	241: true, // MEMORY_LIMIT_EXCEEDED
+	242: true, // TABLE_IS_READ_ONLY
+	252: true, // TOO_MANY_PARTS
+	285: true, // TOO_FEW_LIVE_REPLICAS
+	319: true, // UNKNOWN_STATUS_OF_INSERT
+	425: true, // SYSTEM_ERROR
+	999: true, // KEEPER_EXCEPTION
+}
+
+func isTransient(err error) bool {
+	var exc *clickhouse.Exception
+	if errors.As(err, &exc) {
+		return transientCodes[exc.Code]
+	}
+	// connection and io errors
+	return true
+}
+
+var insertTableRe = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+([^\s(]+)`)
+
+// insertTable returns the table an insert query writes to, or an empty string if it can't be found.
+func insertTable(query string) string {
+	m := insertTableRe.FindStringSubmatch(query)
+	if m == nil {
+		return ""
 	}
+	return strings.ReplaceAll(m[1], "`", "")
 }
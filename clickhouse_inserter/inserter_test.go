@@ -0,0 +1,21 @@
+package clickhouse_inserter
+
+import "testing"
+
+func TestInsertTable(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"INSERT INTO follow (uri, did, rkey)", "follow"},
+		{"insert into `db`.`post`(uri)", "db.post"},
+		{"INSERT INTO plc (\n\tdid,\n\tcid\n)", "plc"},
+		{"SELECT 1", ""},
+	}
+
+	for _, tt := range tests {
+		if got := insertTable(tt.query); got != tt.want {
+			t.Errorf("insertTable(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/haileyok/photocopy"
+	"github.com/haileyok/photocopy/clickhouse_inserter"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/urfave/cli/v2"
 )
@@ -17,10 +21,23 @@ func main() {
 		Name:  "photocopy",
 		Usage: "bigquery inserter for firehose events",
 		Flags: []cli.Flag{
-			&cli.StringFlag{
+			&cli.StringSliceFlag{
 				Name:    "relay-host",
+				Usage:   "relay to consume from. may be given more than once, in which case the first is the primary. relays after the first keep their cursor in the cursor file with the host appended",
 				EnvVars: []string{"PHOTOCOPY_RELAY_HOST"},
-				Value:   "wss://bsky.network",
+				Value:   cli.NewStringSlice("wss://bsky.network"),
+			},
+			&cli.StringFlag{
+				Name:    "relay-mode",
+				Usage:   "parallel consumes every relay at once and dedupes, failover consumes one at a time and moves to the next when it stalls or keeps failing",
+				EnvVars: []string{"PHOTOCOPY_RELAY_MODE"},
+				Value:   "parallel",
+			},
+			&cli.DurationFlag{
+				Name:    "relay-stall-timeout",
+				Usage:   "reconnect (or fail over) when a relay sends nothing for this long",
+				EnvVars: []string{"PHOTOCOPY_RELAY_STALL_TIMEOUT"},
+				Value:   2 * time.Minute,
 			},
 			&cli.StringFlag{
 				Name:    "metrics-addr",
@@ -33,24 +50,20 @@ func main() {
 				Value:   "info",
 			},
 			&cli.StringFlag{
-				Name:     "cursor-file",
-				EnvVars:  []string{"PHOTOCOPY_CURSOR_FILE"},
-				Required: true,
+				Name:    "cursor-file",
+				EnvVars: []string{"PHOTOCOPY_CURSOR_FILE"},
 			},
 			&cli.StringFlag{
-				Name:     "plc-scraper-cursor-file",
-				EnvVars:  []string{"PHOTOCOPY_PLC_SCRAPER_CURSOR_FILE"},
-				Required: true,
+				Name:    "plc-scraper-cursor-file",
+				EnvVars: []string{"PHOTOCOPY_PLC_SCRAPER_CURSOR_FILE"},
 			},
 			&cli.StringFlag{
-				Name:     "clickhouse-addr",
-				EnvVars:  []string{"PHOTOCOPY_CLICKHOUSE_ADDR"},
-				Required: true,
+				Name:    "clickhouse-addr",
+				EnvVars: []string{"PHOTOCOPY_CLICKHOUSE_ADDR"},
 			},
 			&cli.StringFlag{
-				Name:     "clickhouse-database",
-				EnvVars:  []string{"PHOTOCOPY_CLICKHOUSE_DATABASE"},
-				Required: true,
+				Name:    "clickhouse-database",
+				EnvVars: []string{"PHOTOCOPY_CLICKHOUSE_DATABASE"},
 			},
 			&cli.StringFlag{
 				Name:    "clickhouse-user",
@@ -58,9 +71,38 @@ func main() {
 				Value:   "default",
 			},
 			&cli.StringFlag{
-				Name:     "clickhouse-pass",
-				EnvVars:  []string{"PHOTOCOPY_CLICKHOUSE_PASS"},
-				Required: true,
+				Name:    "clickhouse-pass",
+				EnvVars: []string{"PHOTOCOPY_CLICKHOUSE_PASS"},
+			},
+			&cli.DurationFlag{
+				Name:    "insert-flush-interval",
+				Usage:   "send partial clickhouse batches at least this often, 0 to only send full batches",
+				EnvVars: []string{"PHOTOCOPY_INSERT_FLUSH_INTERVAL"},
+				Value:   10 * time.Second,
+			},
+			&cli.IntFlag{
+				Name:    "insert-max-retries",
+				Usage:   "times to retry a clickhouse batch after a transient error before dead lettering it",
+				EnvVars: []string{"PHOTOCOPY_INSERT_MAX_RETRIES"},
+				Value:   5,
+			},
+			&cli.DurationFlag{
+				Name:    "insert-retry-backoff",
+				Usage:   "backoff before the first retry of a clickhouse batch, doubling with each attempt",
+				EnvVars: []string{"PHOTOCOPY_INSERT_RETRY_BACKOFF"},
+				Value:   time.Second,
+			},
+			&cli.IntFlag{
+				Name:    "insert-send-workers",
+				Usage:   "clickhouse batches each table can have in flight at once before inserts start blocking",
+				EnvVars: []string{"PHOTOCOPY_INSERT_SEND_WORKERS"},
+				Value:   2,
+			},
+			&cli.StringFlag{
+				Name:    "dead-letter-dir",
+				Usage:   "directory to write batches that could not be inserted to. empty drops them",
+				EnvVars: []string{"PHOTOCOPY_DEAD_LETTER_DIR"},
+				Value:   "dlq",
 			},
 			&cli.StringFlag{
 				Name:     "ratelimit-bypass-key",
@@ -78,12 +120,161 @@ func main() {
 				Name:    "nervana-api-key",
 				EnvVars: []string{"PHOTOCOPY_NERVANA_API_KEY"},
 			},
+			&cli.StringFlag{
+				Name:    "source",
+				Usage:   "where to consume events from, either firehose or jetstream. the cursor file is not portable between the two",
+				EnvVars: []string{"PHOTOCOPY_SOURCE"},
+				Value:   "firehose",
+			},
+			&cli.StringSliceFlag{
+				Name:    "jetstream-wanted-collections",
+				Usage:   "collections to request from jetstream, defaults to all of them",
+				EnvVars: []string{"PHOTOCOPY_JETSTREAM_WANTED_COLLECTIONS"},
+			},
+			&cli.IntFlag{
+				Name:    "workers",
+				Usage:   "number of workers processing firehose events. events for a single did always go to the same worker",
+				EnvVars: []string{"PHOTOCOPY_WORKERS"},
+				Value:   64,
+			},
+			&cli.IntFlag{
+				Name:    "worker-queue-size",
+				Usage:   "events buffered per worker before reading from the relay is paused",
+				EnvVars: []string{"PHOTOCOPY_WORKER_QUEUE_SIZE"},
+				Value:   100,
+			},
+			&cli.IntFlag{
+				Name:    "nervana-workers",
+				EnvVars: []string{"PHOTOCOPY_NERVANA_WORKERS"},
+				Value:   32,
+			},
+			&cli.StringSliceFlag{
+				Name:    "record-collections",
+				Usage:   "collection globs to store in the record table, e.g. app.bsky.* or !app.bsky.feed.like. defaults to everything",
+				EnvVars: []string{"PHOTOCOPY_RECORD_COLLECTIONS"},
+			},
+			&cli.StringFlag{
+				Name:    "record-did-allowlist",
+				Usage:   "file of dids, one per line, to limit the record table to",
+				EnvVars: []string{"PHOTOCOPY_RECORD_DID_ALLOWLIST"},
+			},
+			&cli.StringFlag{
+				Name:    "record-did-denylist",
+				Usage:   "file of dids, one per line, to leave out of the record table",
+				EnvVars: []string{"PHOTOCOPY_RECORD_DID_DENYLIST"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "typed-collections",
+				Usage:   "collection globs to store in the typed tables (post, follow, interaction, ...). defaults to everything",
+				EnvVars: []string{"PHOTOCOPY_TYPED_COLLECTIONS"},
+			},
+			&cli.StringFlag{
+				Name:    "typed-did-allowlist",
+				Usage:   "file of dids, one per line, to limit the typed tables to",
+				EnvVars: []string{"PHOTOCOPY_TYPED_DID_ALLOWLIST"},
+			},
+			&cli.StringFlag{
+				Name:    "typed-did-denylist",
+				Usage:   "file of dids, one per line, to leave out of the typed tables",
+				EnvVars: []string{"PHOTOCOPY_TYPED_DID_DENYLIST"},
+			},
+			&cli.StringFlag{
+				Name:    "record-json",
+				Usage:   "off, also or instead. also stores a dag-json rendering of each record next to the raw cbor in the record table, instead stores only the json",
+				EnvVars: []string{"PHOTOCOPY_RECORD_JSON"},
+				Value:   "off",
+			},
+			&cli.BoolFlag{
+				Name:    "delete-cascade",
+				Usage:   "also remove deleted records from the typed tables with lightweight deletes. requires a non-zero insert-flush-interval",
+				EnvVars: []string{"PHOTOCOPY_DELETE_CASCADE"},
+			},
+			&cli.BoolFlag{
+				Name:    "purge-deleted-accounts",
+				Usage:   "purge what is stored about an account when an #account event reports it deleted, keeping its delete, identity and account rows. pending purges are kept next to the cursor file, and a non-zero insert-flush-interval is required",
+				EnvVars: []string{"PHOTOCOPY_PURGE_DELETED_ACCOUNTS"},
+			},
+			&cli.BoolFlag{
+				Name:    "verify-commits",
+				Usage:   "verify commit signatures and mst diffs, writing failures to verification_failure instead of ingesting them",
+				EnvVars: []string{"PHOTOCOPY_VERIFY_COMMITS"},
+			},
 		},
 		Commands: cli.Commands{
 			&cli.Command{
 				Name:   "run",
 				Action: run,
 			},
+			&cli.Command{
+				Name:   "capture",
+				Usage:  "write raw firehose frames to compressed segment files",
+				Action: runCapture,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "dir",
+						Usage:    "directory to write segments to",
+						EnvVars:  []string{"PHOTOCOPY_CAPTURE_DIR"},
+						Required: true,
+					},
+					&cli.IntFlag{
+						Name:  "segment-frames",
+						Usage: "number of frames per segment file",
+						Value: 100_000,
+					},
+				},
+			},
+			&cli.Command{
+				Name:   "replay",
+				Usage:  "feed captured segments through ingestion as if they came from the relay",
+				Action: runReplay,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "dir",
+						Usage:    "directory to read segments from",
+						EnvVars:  []string{"PHOTOCOPY_CAPTURE_DIR"},
+						Required: true,
+					},
+					&cli.Int64Flag{
+						Name:  "from-seq",
+						Usage: "first seq to replay, inclusive",
+					},
+					&cli.Int64Flag{
+						Name:  "to-seq",
+						Usage: "last seq to replay, inclusive. replays to the end of the capture when unset",
+					},
+				},
+			},
+			&cli.Command{
+				Name:   "purge",
+				Usage:  "remove everything stored about one or more dids",
+				Action: runPurge,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:     "did",
+						Usage:    "did to purge, can be given more than once",
+						Required: true,
+					},
+					&cli.BoolFlag{
+						Name:  "subjects",
+						Usage: "also purge other accounts' rows where the did is the subject, like replies, follows and likes",
+					},
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "only report the rows that would be purged",
+					},
+				},
+			},
+			&cli.Command{
+				Name:  "dlq",
+				Usage: "manage batches that could not be inserted",
+				Subcommands: cli.Commands{
+					&cli.Command{
+						Name:   "replay",
+						Usage:  "re-insert every dead lettered batch, removing each file once it is inserted",
+						Action: runDlqReplay,
+					},
+				},
+			},
 			&cli.Command{
 				Name:   "fetch-repos",
 				Action: runFetchRepos,
@@ -100,6 +291,120 @@ var run = func(cmd *cli.Context) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	l := newLogger(cmd)
+
+	if err := requireFlags(cmd, "cursor-file", "plc-scraper-cursor-file"); err != nil {
+		return err
+	}
+
+	p, err := newPhotocopy(ctx, cmd, l)
+	if err != nil {
+		panic(err)
+	}
+
+	go waitForSignal(l, cancel)
+
+	if err := p.Run(ctx, cmd.Bool("with-backfill")); err != nil {
+		panic(err)
+	}
+
+	return nil
+}
+
+var runCapture = func(cmd *cli.Context) error {
+	ctx := cmd.Context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l := newLogger(cmd)
+
+	go waitForSignal(l, cancel)
+
+	return photocopy.Capture(ctx, photocopy.CaptureArgs{
+		Logger:        l,
+		RelayHost:     cmd.StringSlice("relay-host")[0],
+		Dir:           cmd.String("dir"),
+		SegmentFrames: cmd.Int("segment-frames"),
+	})
+}
+
+var runReplay = func(cmd *cli.Context) error {
+	ctx := cmd.Context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l := newLogger(cmd)
+
+	p, err := newPhotocopy(ctx, cmd, l)
+	if err != nil {
+		return err
+	}
+
+	go waitForSignal(l, cancel)
+
+	return p.Replay(ctx, cmd.String("dir"), cmd.Int64("from-seq"), cmd.Int64("to-seq"))
+}
+
+var runPurge = func(cmd *cli.Context) error {
+	ctx := cmd.Context
+
+	l := newLogger(cmd)
+
+	if err := requireFlags(cmd, "clickhouse-addr", "clickhouse-database", "clickhouse-pass"); err != nil {
+		return err
+	}
+
+	conn, err := photocopy.OpenClickhouse(cmd.String("clickhouse-addr"), cmd.String("clickhouse-database"), cmd.String("clickhouse-user"), cmd.String("clickhouse-pass"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	opts := photocopy.PurgeOptions{
+		Subjects: cmd.Bool("subjects"),
+		DryRun:   cmd.Bool("dry-run"),
+	}
+
+	for _, did := range cmd.StringSlice("did") {
+		counts, err := photocopy.Purge(ctx, conn, did, opts)
+		for _, c := range counts {
+			l.Info("purge", "did", did, "table", c.Table, "column", c.Column, "rows", c.Rows, "dry_run", opts.DryRun)
+		}
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("failed to purge %s: %v", did, err), 1)
+		}
+	}
+
+	return nil
+}
+
+var runDlqReplay = func(cmd *cli.Context) error {
+	ctx := cmd.Context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l := newLogger(cmd)
+
+	if err := requireFlags(cmd, "clickhouse-addr", "clickhouse-database", "clickhouse-pass", "dead-letter-dir"); err != nil {
+		return err
+	}
+
+	conn, err := photocopy.OpenClickhouse(cmd.String("clickhouse-addr"), cmd.String("clickhouse-database"), cmd.String("clickhouse-user"), cmd.String("clickhouse-pass"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go waitForSignal(l, cancel)
+
+	if err := clickhouse_inserter.ReplayDeadLetters(ctx, conn, cmd.String("dead-letter-dir"), l); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	return nil
+}
+
+func newLogger(cmd *cli.Context) *slog.Logger {
 	var level slog.Level
 	switch cmd.String("log-level") {
 	case "debug":
@@ -114,13 +419,21 @@ var run = func(cmd *cli.Context) error {
 		level = slog.LevelInfo
 	}
 
-	l := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
 	}))
+}
 
-	p, err := photocopy.New(ctx, &photocopy.Args{
+func newPhotocopy(ctx context.Context, cmd *cli.Context, l *slog.Logger) (*photocopy.Photocopy, error) {
+	if err := requireFlags(cmd, "clickhouse-addr", "clickhouse-database", "clickhouse-pass"); err != nil {
+		return nil, err
+	}
+
+	return photocopy.New(ctx, &photocopy.Args{
 		Logger:               l,
-		RelayHost:            cmd.String("relay-host"),
+		RelayHosts:           cmd.StringSlice("relay-host"),
+		RelayMode:            cmd.String("relay-mode"),
+		RelayStallTimeout:    cmd.Duration("relay-stall-timeout"),
 		MetricsAddr:          cmd.String("metrics-addr"),
 		CursorFile:           cmd.String("cursor-file"),
 		PLCScraperCursorFile: cmd.String("plc-scraper-cursor-file"),
@@ -131,26 +444,58 @@ var run = func(cmd *cli.Context) error {
 		RatelimitBypassKey:   cmd.String("ratelimit-bypass-key"),
 		NervanaEndpoint:      cmd.String("nervana-endpoint"),
 		NervanaApiKey:        cmd.String("nervana-api-key"),
+		VerifyCommits:        cmd.Bool("verify-commits"),
+		Source:               cmd.String("source"),
+		JetstreamCollections: cmd.StringSlice("jetstream-wanted-collections"),
+		Workers:              cmd.Int("workers"),
+		WorkerQueueSize:      cmd.Int("worker-queue-size"),
+		NervanaWorkers:       cmd.Int("nervana-workers"),
+		RecordFilter: photocopy.FilterArgs{
+			Collections:  cmd.StringSlice("record-collections"),
+			DidAllowFile: cmd.String("record-did-allowlist"),
+			DidDenyFile:  cmd.String("record-did-denylist"),
+		},
+		TypedFilter: photocopy.FilterArgs{
+			Collections:  cmd.StringSlice("typed-collections"),
+			DidAllowFile: cmd.String("typed-did-allowlist"),
+			DidDenyFile:  cmd.String("typed-did-denylist"),
+		},
+		RecordJSON:           cmd.String("record-json"),
+		DeleteCascade:        cmd.Bool("delete-cascade"),
+		PurgeDeletedAccounts: cmd.Bool("purge-deleted-accounts"),
+		InsertFlushInterval:  cmd.Duration("insert-flush-interval"),
+		InsertMaxRetries:     cmd.Int("insert-max-retries"),
+		InsertRetryBackoff:   cmd.Duration("insert-retry-backoff"),
+		DeadLetterDir:        cmd.String("dead-letter-dir"),
+		InsertSendWorkers:    cmd.Int("insert-send-workers"),
 	})
-	if err != nil {
-		panic(err)
+}
+
+// The clickhouse and cursor flags aren't needed by every command (capture doesn't touch clickhouse at
+// all), so they're checked here instead of being marked required on the app.
+func requireFlags(cmd *cli.Context, names ...string) error {
+	var missing []string
+	for _, name := range names {
+		if cmd.String(name) == "" {
+			missing = append(missing, name)
+		}
 	}
 
-	go func() {
-		exitSignals := make(chan os.Signal, 1)
-		signal.Notify(exitSignals, syscall.SIGINT, syscall.SIGTERM)
+	if len(missing) > 0 {
+		return cli.Exit(fmt.Sprintf("Required flags %q not set", strings.Join(missing, ", ")), 1)
+	}
 
-		sig := <-exitSignals
+	return nil
+}
 
-		l.Info("received os exit signal", "signal", sig)
-		cancel()
-	}()
+func waitForSignal(l *slog.Logger, cancel context.CancelFunc) {
+	exitSignals := make(chan os.Signal, 1)
+	signal.Notify(exitSignals, syscall.SIGINT, syscall.SIGTERM)
 
-	if err := p.Run(ctx, cmd.Bool("with-backfill")); err != nil {
-		panic(err)
-	}
+	sig := <-exitSignals
 
-	return nil
+	l.Info("received os exit signal", "signal", sig)
+	cancel()
 }
 
 var runFetchRepos = func(cmd *cli.Context) error {
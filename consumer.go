@@ -3,118 +3,398 @@ package photocopy
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/bluesky-social/indigo/events"
-	"github.com/bluesky-social/indigo/events/schedulers/parallel"
+	"github.com/bluesky-social/indigo/events/schedulers/sequential"
 	"github.com/bluesky-social/indigo/repo"
 	"github.com/bluesky-social/indigo/repomgr"
 	"github.com/gorilla/websocket"
 	"github.com/ipfs/go-cid"
 )
 
+const (
+	minReconnectBackoff = 1 * time.Second
+	maxReconnectBackoff = 2 * time.Minute
+	// a connection that stays up at least this long resets the backoff
+	stableConnectionTime = 1 * time.Minute
+)
+
+// startWorkers loads each relay's cursor and starts everything that handles events, so it has all
+// been set up before Run comes to wait on it at shutdown.
+func (p *Photocopy) startWorkers(ctx context.Context) error {
+	for _, r := range p.relays {
+		if err := r.initCursor(); err != nil {
+			return err
+		}
+		go p.runCursorSaver(ctx, r)
+	}
+
+	p.pool.run(ctx)
+	if p.source == SourceFirehose {
+		p.runTooBigRecoverer(ctx)
+	}
+	if p.nervanaClient != nil {
+		p.runNervanaWorkers(ctx)
+	}
+
+	return nil
+}
+
 func (p *Photocopy) startConsumer(ctx context.Context, cancel context.CancelFunc) error {
 	defer cancel()
 
-	go func() {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
+	if p.relayMode == RelayModeFailover {
+		return p.runFailover(ctx)
+	}
 
-		for range ticker.C {
-			if err := os.WriteFile(p.cursorFile, []byte(p.cursor), 0644); err != nil {
-				p.logger.Error("error saving cursor", "error", err)
+	return p.runParallel(ctx)
+}
+
+// runRelay keeps a connection to a single relay open, reconnecting with backoff whenever it drops. When
+// failover is set it gives up and returns once the relay looks unhealthy so the caller can move on.
+func (p *Photocopy) runRelay(ctx context.Context, r *relay, failover bool) error {
+	rsc := p.streamCallbacks(ctx, r)
+	logger := p.logger.With("relay", r.host)
+
+	attempt := 0
+	failures := 0
+	var disconnectedAt time.Time
+	for {
+		// resume from whatever was last written to disk, not from whatever is in flight
+		prevCursor, err := r.loadCursor()
+		if err != nil && !os.IsNotExist(err) {
+			logger.Error("error loading cursor", "error", err)
+		}
+
+		u, err := p.subscribeURL(r.host, prevCursor)
+		if err != nil {
+			return err
+		}
+
+		connectedAt, err := p.consumeRelay(ctx, r, u, &rsc, func() {
+			if !disconnectedAt.IsZero() {
+				outage := time.Since(disconnectedAt)
+				relayReconnects.WithLabelValues(r.host).Inc()
+				relayDowntime.WithLabelValues(r.host).Add(outage.Seconds())
+				relayOutageDuration.WithLabelValues(r.host).Observe(outage.Seconds())
+				logger.Info("reconnected to relay", "downtime", outage, "attempt", attempt)
 			}
-			p.logger.Debug("saving cursor", "seq", p.cursor)
+		})
+		if ctx.Err() != nil {
+			logger.Info("repo stream shut down")
+			return nil
 		}
-	}()
 
-	u, err := url.Parse(p.relayHost)
-	if err != nil {
-		return err
-	}
-	u.Path = "/xrpc/com.atproto.sync.subscribeRepos"
+		if !connectedAt.IsZero() {
+			disconnectedAt = time.Now()
+			if time.Since(connectedAt) >= stableConnectionTime {
+				attempt = 0
+				failures = 0
+			}
+		} else if disconnectedAt.IsZero() {
+			disconnectedAt = time.Now()
+		}
 
-	prevCursor, err := p.loadCursor()
-	if err != nil {
-		if !os.IsNotExist(err) {
-			panic(err)
+		failures++
+		if failover && (errors.Is(err, errRelayStalled) || failures >= failoverAttempts) {
+			return err
 		}
-	} else {
-		p.cursor = prevCursor
-	}
 
-	if prevCursor != "" {
-		u.RawQuery = "cursor=" + prevCursor
+		wait := reconnectBackoff(attempt)
+		attempt++
+
+		logger.Warn("relay connection lost, reconnecting", "error", err, "attempt", attempt, "wait", wait)
+
+		select {
+		case <-ctx.Done():
+			logger.Info("repo stream shut down")
+			return nil
+		case <-time.After(wait):
+		}
 	}
+}
 
-	rsc := events.RepoStreamCallbacks{
+func (p *Photocopy) streamCallbacks(ctx context.Context, r *relay) events.RepoStreamCallbacks {
+	return events.RepoStreamCallbacks{
 		RepoCommit: func(evt *atproto.SyncSubscribeRepos_Commit) error {
-			go p.repoCommit(ctx, evt)
-			return nil
+			r.observe(evt.Seq, evt.Time)
+			// the sequential scheduler calls us in stream order, so begin() sees seqs in order even
+			// though the commits themselves are processed concurrently
+			r.cursor.begin(evt.Seq)
+			return p.pool.submit(ctx, evt.Repo, func() {
+				// a too big commit stays in flight until the recovery workers are done with it, and one
+				// that couldn't be verified before shutdown stays in flight for good
+				if !p.repoCommit(ctx, r, evt) {
+					r.cursor.done(evt.Seq)
+				}
+			})
+		},
+		RepoIdentity: func(evt *atproto.SyncSubscribeRepos_Identity) error {
+			r.observe(evt.Seq, evt.Time)
+			r.cursor.begin(evt.Seq)
+			return p.pool.submit(ctx, evt.Did, func() {
+				defer r.cursor.done(evt.Seq)
+				key := "identity|" + evt.Did + "|" + evt.Time
+				if p.dedup.checkAndMark(key) {
+					relayDuplicates.WithLabelValues(r.host).Inc()
+					return
+				}
+				var handle string
+				if evt.Handle != nil {
+					handle = *evt.Handle
+				}
+				if err := p.handleIdentity(ctx, evt.Did, handle, evt.Seq, evt.Time); err != nil {
+					p.logger.Error("error handling identity event", "error", err)
+					p.dedup.unmark(key)
+				}
+			})
+		},
+		RepoAccount: func(evt *atproto.SyncSubscribeRepos_Account) error {
+			r.observe(evt.Seq, evt.Time)
+			r.cursor.begin(evt.Seq)
+			return p.pool.submit(ctx, evt.Did, func() {
+				defer r.cursor.done(evt.Seq)
+				key := "account|" + evt.Did + "|" + evt.Time
+				if p.dedup.checkAndMark(key) {
+					relayDuplicates.WithLabelValues(r.host).Inc()
+					return
+				}
+				var status string
+				if evt.Status != nil {
+					status = *evt.Status
+				}
+				if err := p.handleAccount(ctx, evt.Did, evt.Active, status, evt.Seq, evt.Time); err != nil {
+					p.logger.Error("error handling account event", "error", err)
+					p.dedup.unmark(key)
+				}
+			})
 		},
 	}
+}
+
+func (p *Photocopy) subscribeURL(host, cursor string) (*url.URL, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{}
+	switch p.source {
+	case SourceJetstream:
+		u.Path = "/subscribe"
+		for _, c := range p.jetstreamCollections {
+			q.Add("wantedCollections", c)
+		}
+	default:
+		u.Path = "/xrpc/com.atproto.sync.subscribeRepos"
+	}
+
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	u.RawQuery = q.Encode()
+
+	return u, nil
+}
 
+// consumeRelay dials the relay once and blocks until the stream ends. The returned time is when the
+// connection was established, or zero if the dial itself failed.
+func (p *Photocopy) consumeRelay(ctx context.Context, r *relay, u *url.URL, rsc *events.RepoStreamCallbacks, onConnect func()) (time.Time, error) {
 	d := websocket.DefaultDialer
 
 	p.logger.Info("connecting to relay", "url", u.String())
 
-	con, _, err := d.Dial(u.String(), http.Header{
+	con, _, err := d.DialContext(ctx, u.String(), http.Header{
 		"user-agent": []string{"photocopy/0.0.0"},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to connect to relay: %w", err)
+		return time.Time{}, fmt.Errorf("failed to connect to relay: %w", err)
 	}
 
-	scheduler := parallel.NewScheduler(400, 10, con.RemoteAddr().String(), rsc.EventHandler)
+	connectedAt := time.Now()
+	relayConnected.WithLabelValues(r.host).Set(1)
+	defer relayConnected.WithLabelValues(r.host).Set(0)
+	onConnect()
+
+	// a relay can keep the socket open while sending nothing, so close it ourselves if events dry up. if
+	// events are still waiting on the workers then we've stopped reading on purpose and it's not a stall
+	r.touch()
+	var stalled atomic.Bool
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(p.stallTimeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if r.sinceLastEvent() > p.stallTimeout && r.cursor.pending() == 0 {
+					p.logger.Warn("relay stalled, closing connection", "relay", r.host, "since_last_event", r.sinceLastEvent())
+					stalled.Store(true)
+					con.Close()
+					return
+				}
+			}
+		}
+	}()
 
-	if err := events.HandleRepoStream(ctx, con, scheduler, p.logger); err != nil {
-		p.logger.Error("repo stream failed", "error", err)
+	var streamErr error
+	if p.source == SourceJetstream {
+		streamErr = p.handleJetstream(ctx, r, con)
+	} else {
+		scheduler := sequential.NewScheduler(con.RemoteAddr().String(), rsc.EventHandler)
+		streamErr = events.HandleRepoStream(ctx, con, scheduler, p.logger)
 	}
 
-	p.logger.Info("repo stream shut down")
+	if stalled.Load() {
+		return connectedAt, errRelayStalled
+	}
+	if streamErr != nil {
+		p.logger.Error("repo stream failed", "relay", r.host, "error", streamErr)
+		return connectedAt, streamErr
+	}
 
-	return nil
+	return connectedAt, fmt.Errorf("repo stream closed")
+}
+
+func reconnectBackoff(attempt int) time.Duration {
+	d := maxReconnectBackoff
+	if attempt < 16 {
+		d = min(minReconnectBackoff<<attempt, maxReconnectBackoff)
+	}
+	// full jitter over the upper half so a fleet of consumers doesn't reconnect in lockstep
+	return d/2 + time.Duration(rand.Int64N(int64(d/2)+1))
 }
 
-func (p *Photocopy) repoCommit(ctx context.Context, evt *atproto.SyncSubscribeRepos_Commit) {
-	p.cursor = fmt.Sprintf("%d", evt.Seq)
+// repoCommit ingests a single commit, returning true if its seq has to stay in flight. That's either
+// because it was handed off to the too big recovery workers, which mark it done instead, or because we
+// shut down before it could be verified.
+func (p *Photocopy) repoCommit(ctx context.Context, rl *relay, evt *atproto.SyncSubscribeRepos_Commit) bool {
+	ops := p.dedupOps(rl, evt)
+	if len(ops) == 0 && len(evt.Ops) != 0 {
+		// every op was already ingested from another relay
+		return false
+	}
+
+	// the ops are claimed now, so if the commit can't be ingested they have to be given back for another
+	// relay's copy to pick up
+	release := func() {
+		for _, op := range ops {
+			p.dedup.unmark(evt.Repo + "|" + evt.Rev + "|" + op.Path)
+		}
+	}
 
 	if evt.TooBig {
-		p.logger.Warn("commit too big", "repo", evt.Repo, "seq", evt.Seq)
-		return
+		key := evt.Repo + "|" + evt.Rev
+		if p.dedup.checkAndMark(key) {
+			relayDuplicates.WithLabelValues(rl.host).Inc()
+			return false
+		}
+		p.logger.Warn("commit too big, queueing for recovery", "repo", evt.Repo, "seq", evt.Seq)
+		if !p.enqueueTooBig(ctx, rl, evt) {
+			p.dedup.unmark(key)
+			release()
+			return false
+		}
+		return true
+	}
+
+	if p.verifyCommits {
+		ok, err := p.verifyCommit(ctx, evt)
+		if err != nil {
+			// shut down before the commit could be verified, so it stays in flight and is verified again
+			// after a restart
+			release()
+			return true
+		}
+		if !ok {
+			release()
+			return false
+		}
 	}
 
 	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(evt.Blocks))
 	if err != nil {
 		p.logger.Error("failed to read event repo", "error", err)
-		return
+		decodeFailures.WithLabelValues("read_car").Inc()
+		release()
+		return false
 	}
 
 	did, err := syntax.ParseDID(evt.Repo)
 	if err != nil {
 		p.logger.Error("failed to parse did", "error", err)
-		return
+		decodeFailures.WithLabelValues("invalid_did").Inc()
+		release()
+		return false
+	}
+
+	p.processOps(ctx, r, did, ops, evt.Time, evt.Rev, evt.Seq)
+
+	if t, err := time.Parse(time.RFC3339Nano, evt.Time); err == nil {
+		ingestLag.Set(time.Since(t).Seconds())
+	}
+
+	return false
+}
+
+// dedupOps drops any ops that have already been ingested from another relay, and claims the rest so
+// another relay's copy of the same commit is skipped.
+func (p *Photocopy) dedupOps(rl *relay, evt *atproto.SyncSubscribeRepos_Commit) []*atproto.SyncSubscribeRepos_RepoOp {
+	if p.dedup == nil {
+		return evt.Ops
 	}
 
+	ops := make([]*atproto.SyncSubscribeRepos_RepoOp, 0, len(evt.Ops))
 	for _, op := range evt.Ops {
+		if p.dedup.checkAndMark(evt.Repo + "|" + evt.Rev + "|" + op.Path) {
+			relayDuplicates.WithLabelValues(rl.host).Inc()
+			continue
+		}
+		ops = append(ops, op)
+	}
+
+	return ops
+}
+
+// processOps ingests the given ops from the repo, returning how many of them couldn't be ingested.
+func (p *Photocopy) processOps(ctx context.Context, r *repo.Repo, did syntax.DID, ops []*atproto.SyncSubscribeRepos_RepoOp, evtTime, rev string, seq int64) int {
+	skipped := 0
+	for _, op := range ops {
 		collection, rkey, err := syntax.ParseRepoPath(op.Path)
 		if err != nil {
 			p.logger.Error("invalid path in repo op")
+			decodeFailures.WithLabelValues("invalid_path").Inc()
+			skipped++
 			continue
 		}
 
 		ek := repomgr.EventKind(op.Action)
+		opsProcessed.WithLabelValues(op.Action, collectionLabel(collection.String())).Inc()
 
 		switch ek {
-		case repomgr.EvtKindCreateRecord:
+		case repomgr.EvtKindCreateRecord, repomgr.EvtKindUpdateRecord:
+			if !p.wantsRecord(did.String(), collection.String()) {
+				continue
+			}
+
 			if op.Cid == nil {
 				p.logger.Warn("op missing reccid", "path", op.Path, "action", op.Action)
+				decodeFailures.WithLabelValues("missing_cid").Inc()
+				skipped++
 				continue
 			}
 
@@ -122,36 +402,90 @@ func (p *Photocopy) repoCommit(ctx context.Context, evt *atproto.SyncSubscribeRe
 			reccid, rec, err := r.GetRecordBytes(ctx, op.Path)
 			if err != nil {
 				p.logger.Error("failed to get record bytes", "error", err, "path", op.Path)
+				decodeFailures.WithLabelValues("record_bytes").Inc()
+				skipped++
 				continue
 			}
 
 			if c != reccid {
 				p.logger.Warn("reccid mismatch", "from_event", c, "from_blocks", reccid, "path", op.Path)
+				reccidMismatches.Inc()
+				skipped++
 				continue
 			}
 
 			if rec == nil {
 				p.logger.Warn("record not found", "reccid", c, "path", op.Path)
+				decodeFailures.WithLabelValues("record_not_found").Inc()
+				skipped++
 				continue
 			}
 
-			if err := p.handleCreate(ctx, *rec, evt.Time, evt.Rev, did.String(), collection.String(), rkey.String(), reccid.String(), fmt.Sprintf("%d", evt.Seq)); err != nil {
+			if ek == repomgr.EvtKindUpdateRecord {
+				if err := p.handleUpdate(ctx, *rec, evtTime, rev, did.String(), collection.String(), rkey.String(), reccid.String(), fmt.Sprintf("%d", seq)); err != nil {
+					p.logger.Error("error handling update event", "error", err)
+					decodeFailures.WithLabelValues("handle_update").Inc()
+					skipped++
+				}
+				continue
+			}
+
+			if err := p.handleCreate(ctx, *rec, evtTime, rev, did.String(), collection.String(), rkey.String(), reccid.String(), fmt.Sprintf("%d", seq)); err != nil {
 				p.logger.Error("error handling create event", "error", err)
+				decodeFailures.WithLabelValues("handle_create").Inc()
+				skipped++
 				continue
 			}
 		case repomgr.EvtKindDeleteRecord:
-			if err := p.handleDelete(ctx, did.String(), collection.String(), rkey.String()); err != nil {
+			if err := p.handleDelete(ctx, did.String(), collection.String(), rkey.String(), rev, fmt.Sprintf("%d", seq)); err != nil {
 				p.logger.Error("error handling delete event", "error", err)
+				skipped++
 				continue
 			}
 		}
 	}
+
+	return skipped
 }
 
-func (p *Photocopy) loadCursor() (string, error) {
-	b, err := os.ReadFile(p.cursorFile)
-	if err != nil {
-		return "", err
+// runCursorSaver periodically persists the relay's watermark. It doesn't save on shutdown, since workers
+// may still be finishing events then, so Run does a final saveCursor once they have all stopped.
+func (p *Photocopy) runCursorSaver(ctx context.Context, r *relay) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.saveCursor(r)
+		}
+	}
+}
+
+func (p *Photocopy) saveCursor(r *relay) {
+	if r.cursor == nil {
+		return
+	}
+
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	// the watermark only covers events that are already done, and any deletes they queued have to be on
+	// disk before the cursor moves past them
+	seq := r.cursor.watermark()
+	p.saveCascades()
+	cursorInflight.WithLabelValues(r.host).Set(float64(r.cursor.pending()))
+	if seq == 0 || seq == r.savedSeq {
+		return
+	}
+
+	if err := writeFileAtomic(r.cursorFile, []byte(strconv.FormatInt(seq, 10)), 0644); err != nil {
+		p.logger.Error("error saving cursor", "relay", r.host, "error", err)
+		return
 	}
-	return string(b), nil
+	r.savedSeq = seq
+	cursorSaved.WithLabelValues(r.host).Set(float64(seq))
+	p.logger.Debug("saving cursor", "relay", r.host, "seq", seq)
 }
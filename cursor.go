@@ -0,0 +1,71 @@
+package photocopy
+
+import (
+	"slices"
+	"sync"
+)
+
+// cursorTracker keeps a low watermark over firehose seqs. Events must be begun in the order they are
+// read off the stream, but may be finished in any order. The watermark only advances once every event
+// at or below it has finished processing, so it is always safe to resume from, and it never moves
+// backwards.
+type cursorTracker struct {
+	mu       sync.Mutex
+	inflight []int64
+	highest  int64
+	// the last watermark handed out. anything at or below it has already been processed once, so an
+	// older seq replayed after a reconnect doesn't pull the watermark back
+	floor int64
+}
+
+func newCursorTracker(start int64) *cursorTracker {
+	return &cursorTracker{
+		highest: start,
+		floor:   start,
+	}
+}
+
+func (t *cursorTracker) begin(seq int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// after a reconnect the relay may replay seqs we have already seen, so keep the list sorted
+	// rather than assuming an append is always in order
+	idx, _ := slices.BinarySearch(t.inflight, seq)
+	t.inflight = slices.Insert(t.inflight, idx, seq)
+
+	if seq > t.highest {
+		t.highest = seq
+	}
+}
+
+func (t *cursorTracker) done(seq int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	idx, found := slices.BinarySearch(t.inflight, seq)
+	if !found {
+		return
+	}
+	t.inflight = slices.Delete(t.inflight, idx, idx+1)
+}
+
+// watermark returns the highest seq for which every seq at or below it has been processed.
+func (t *cursorTracker) watermark() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wm := t.highest
+	if len(t.inflight) > 0 {
+		wm = t.inflight[0] - 1
+	}
+
+	t.floor = max(t.floor, wm)
+	return t.floor
+}
+
+func (t *cursorTracker) pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inflight)
+}
@@ -0,0 +1,123 @@
+package photocopy
+
+import "testing"
+
+func TestCursorTrackerWatermark(t *testing.T) {
+	type step struct {
+		begin []int64
+		done  []int64
+		want  int64
+	}
+
+	tests := []struct {
+		name  string
+		start int64
+		steps []step
+	}{
+		{
+			name:  "nothing in flight keeps the start",
+			start: 10,
+			steps: []step{{want: 10}},
+		},
+		{
+			name:  "in order",
+			start: 0,
+			steps: []step{
+				{begin: []int64{1, 2, 3}, want: 0},
+				{done: []int64{1}, want: 1},
+				{done: []int64{2, 3}, want: 3},
+			},
+		},
+		{
+			name:  "out of order done holds at the oldest in flight",
+			start: 0,
+			steps: []step{
+				{begin: []int64{5, 6, 7}, want: 4},
+				{done: []int64{7}, want: 4},
+				{done: []int64{6}, want: 4},
+				{done: []int64{5}, want: 7},
+			},
+		},
+		{
+			name:  "gaps in seqs",
+			start: 100,
+			steps: []step{
+				{begin: []int64{105, 110}, done: []int64{105}, want: 109},
+				{done: []int64{110}, want: 110},
+			},
+		},
+		{
+			name:  "duplicate seqs after a reconnect",
+			start: 0,
+			steps: []step{
+				{begin: []int64{1, 2, 3}, done: []int64{1, 2}, want: 2},
+				// the relay replays 2 and 3 before 3 has finished the first time. 2 was already processed,
+				// so the watermark stays put
+				{begin: []int64{2, 3}, want: 2},
+				{done: []int64{2}, want: 2},
+				{done: []int64{3}, want: 2},
+				{done: []int64{3}, want: 3},
+			},
+		},
+		{
+			name:  "done for an unknown seq is ignored",
+			start: 0,
+			steps: []step{
+				{begin: []int64{4}, done: []int64{9}, want: 3},
+				{done: []int64{4}, want: 4},
+			},
+		},
+		{
+			name:  "an older seq replayed after draining never moves the watermark back",
+			start: 0,
+			steps: []step{
+				{begin: []int64{8}, done: []int64{8}, want: 8},
+				{begin: []int64{6}, want: 8},
+				{done: []int64{6}, want: 8},
+				{begin: []int64{9, 10}, done: []int64{10}, want: 8},
+				{done: []int64{9}, want: 10},
+			},
+		},
+		{
+			name:  "a seq below the start never moves the watermark back",
+			start: 50,
+			steps: []step{
+				{begin: []int64{40}, want: 50},
+				{done: []int64{40}, want: 50},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct := newCursorTracker(tt.start)
+			for i, s := range tt.steps {
+				for _, seq := range s.begin {
+					ct.begin(seq)
+				}
+				for _, seq := range s.done {
+					ct.done(seq)
+				}
+				if got := ct.watermark(); got != s.want {
+					t.Fatalf("step %d: watermark = %d, want %d", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCursorTrackerPending(t *testing.T) {
+	ct := newCursorTracker(0)
+	ct.begin(1)
+	ct.begin(2)
+	ct.begin(2)
+	if got := ct.pending(); got != 3 {
+		t.Fatalf("pending = %d, want 3", got)
+	}
+
+	ct.done(2)
+	ct.done(1)
+	if got := ct.pending(); got != 1 {
+		t.Fatalf("pending = %d, want 1", got)
+	}
+}
@@ -0,0 +1,130 @@
+package photocopy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Filter decides which records get ingested based on their collection and author. Collection patterns are
+// NSID globs like app.bsky.*, and a pattern prefixed with ! excludes matching collections instead. When no
+// include patterns are given every collection is included, and exclusions always win over inclusions.
+type Filter struct {
+	include   []string
+	exclude   []string
+	allowDids map[string]struct{}
+	denyDids  map[string]struct{}
+}
+
+type FilterArgs struct {
+	Collections  []string
+	DidAllowFile string
+	DidDenyFile  string
+}
+
+func NewFilter(args FilterArgs) (*Filter, error) {
+	f := &Filter{}
+
+	for _, c := range args.Collections {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+
+		exclude := strings.HasPrefix(c, "!")
+		pattern := strings.TrimPrefix(c, "!")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid collection pattern %q: %w", c, err)
+		}
+
+		if exclude {
+			f.exclude = append(f.exclude, pattern)
+		} else {
+			f.include = append(f.include, pattern)
+		}
+	}
+
+	if args.DidAllowFile != "" {
+		dids, err := loadDidList(args.DidAllowFile)
+		if err != nil {
+			return nil, err
+		}
+		f.allowDids = dids
+	}
+
+	if args.DidDenyFile != "" {
+		dids, err := loadDidList(args.DidDenyFile)
+		if err != nil {
+			return nil, err
+		}
+		f.denyDids = dids
+	}
+
+	return f, nil
+}
+
+func (f *Filter) Allow(did, collection string) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.allowDids != nil {
+		if _, ok := f.allowDids[did]; !ok {
+			return false
+		}
+	}
+
+	if _, ok := f.denyDids[did]; ok {
+		return false
+	}
+
+	for _, pattern := range f.exclude {
+		if ok, _ := path.Match(pattern, collection); ok {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+
+	for _, pattern := range f.include {
+		if ok, _ := path.Match(pattern, collection); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadDidList reads one did per line, skipping blank lines and # comments.
+func loadDidList(file string) (map[string]struct{}, error) {
+	fi, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open did list: %w", err)
+	}
+	defer fi.Close()
+
+	dids := map[string]struct{}{}
+	scanner := bufio.NewScanner(fi)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dids[line] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read did list: %w", err)
+	}
+
+	return dids, nil
+}
+
+// wantsRecord reports whether a record would be stored anywhere, so callers can skip decoding it if not.
+func (p *Photocopy) wantsRecord(did, collection string) bool {
+	return p.recordFilter.Allow(did, collection) || p.typedFilter.Allow(did, collection)
+}
@@ -0,0 +1,84 @@
+package photocopy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilterCollections(t *testing.T) {
+	tests := []struct {
+		name        string
+		collections []string
+		collection  string
+		want        bool
+	}{
+		{"no patterns allows everything", nil, "com.example.thing", true},
+		{"include glob matches across dots", []string{"app.bsky.*"}, "app.bsky.feed.post", true},
+		{"glob does not match the bare prefix", []string{"app.bsky.*"}, "app.bsky", false},
+		{"include misses", []string{"app.bsky.feed.post"}, "app.bsky.feed.like", false},
+		{"exclude only allows the rest", []string{"!app.bsky.feed.like"}, "app.bsky.feed.post", true},
+		{"exclude only drops the match", []string{"!app.bsky.feed.like"}, "app.bsky.feed.like", false},
+		{"exclude wins over include", []string{"app.bsky.*", "!app.bsky.feed.like"}, "app.bsky.feed.like", false},
+		{"exclude wins regardless of order", []string{"!app.bsky.feed.like", "app.bsky.*"}, "app.bsky.feed.like", false},
+		{"include next to an exclude", []string{"app.bsky.*", "!app.bsky.feed.like"}, "app.bsky.graph.follow", true},
+		{"blank patterns are ignored", []string{" ", "app.bsky.feed.post"}, "app.bsky.feed.post", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(FilterArgs{Collections: tt.collections})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Allow("did:plc:a", tt.collection); got != tt.want {
+				t.Fatalf("Allow(%q) = %v, want %v", tt.collection, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterInvalidPattern(t *testing.T) {
+	if _, err := NewFilter(FilterArgs{Collections: []string{"app.bsky.["}}); err == nil {
+		t.Fatal("expected an error for a malformed pattern")
+	}
+}
+
+func TestFilterDids(t *testing.T) {
+	dir := t.TempDir()
+	allow := filepath.Join(dir, "allow")
+	deny := filepath.Join(dir, "deny")
+	if err := os.WriteFile(allow, []byte("# comment\ndid:plc:a\n\ndid:plc:b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(deny, []byte("did:plc:b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewFilter(FilterArgs{DidAllowFile: allow, DidDenyFile: deny})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		did  string
+		want bool
+	}{
+		{"did:plc:a", true},
+		{"did:plc:b", false}, // deny wins over allow
+		{"did:plc:c", false}, // not on the allow list
+	}
+
+	for _, tt := range tests {
+		if got := f.Allow(tt.did, "app.bsky.feed.post"); got != tt.want {
+			t.Errorf("Allow(%q) = %v, want %v", tt.did, got, tt.want)
+		}
+	}
+}
+
+func TestNilFilterAllows(t *testing.T) {
+	var f *Filter
+	if !f.Allow("did:plc:a", "app.bsky.feed.post") {
+		t.Fatal("a nil filter should allow everything")
+	}
+}
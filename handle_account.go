@@ -0,0 +1,61 @@
+package photocopy
+
+import (
+	"context"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/haileyok/photocopy/models"
+)
+
+func (p *Photocopy) handleIdentity(ctx context.Context, did, handle string, seq int64, evtTime string) error {
+	t, err := dateparse.ParseAny(evtTime)
+	if err != nil {
+		return err
+	}
+
+	ie := models.IdentityEvent{
+		Did:       did,
+		Seq:       seq,
+		Handle:    handle,
+		Time:      t,
+		IndexedAt: time.Now(),
+	}
+
+	if err := p.inserters.identitiesInserter.Insert(ctx, ie); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Photocopy) handleAccount(ctx context.Context, did string, active bool, status string, seq int64, evtTime string) error {
+	t, err := dateparse.ParseAny(evtTime)
+	if err != nil {
+		return err
+	}
+
+	// the status is only set when the account is inactive, so fill it in to keep the timeline readable
+	if status == "" && active {
+		status = "active"
+	}
+
+	ae := models.AccountEvent{
+		Did:       did,
+		Seq:       seq,
+		Active:    active,
+		Status:    status,
+		Time:      t,
+		IndexedAt: time.Now(),
+	}
+
+	if err := p.inserters.accountsInserter.Insert(ctx, ae); err != nil {
+		return err
+	}
+
+	if status == "deleted" {
+		p.enqueuePurge(did)
+	}
+
+	return nil
+}
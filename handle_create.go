@@ -4,39 +4,73 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"net/url"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/araddon/dateparse"
+	"github.com/bluesky-social/indigo/api/atproto"
 	"github.com/bluesky-social/indigo/api/bsky"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/haileyok/photocopy/models"
 )
 
 func (p *Photocopy) handleCreate(ctx context.Context, recb []byte, indexedAt, rev, did, collection, rkey, cid, seq string) error {
+	return p.handleRecord(ctx, recb, indexedAt, rev, did, collection, rkey, cid, seq, false)
+}
+
+func (p *Photocopy) handleRecord(ctx context.Context, recb []byte, indexedAt, rev, did, collection, rkey, cid, seq string, update bool) error {
 	iat, err := dateparse.ParseAny(indexedAt)
 	if err != nil {
 		return err
 	}
 
-	if err := p.handleCreateRecord(ctx, did, rkey, collection, cid, recb, seq); err != nil {
+	if err := p.handleCreateRecord(ctx, did, rkey, collection, cid, recb, seq, rev); err != nil {
 		p.logger.Error("error creating record", "error", err)
 	}
 
+	if !p.typedFilter.Allow(did, collection) {
+		return nil
+	}
+
 	switch collection {
 	case "app.bsky.feed.post":
-		return p.handleCreatePost(ctx, rev, recb, uriFromParts(did, collection, rkey), did, collection, rkey, cid, iat)
+		return p.handleCreatePost(ctx, rev, recb, uriFromParts(did, collection, rkey), did, collection, rkey, cid, iat, update)
 	case "app.bsky.graph.follow":
-		return p.handleCreateFollow(ctx, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+		return p.handleCreateFollow(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
 	case "app.bsky.feed.like", "app.bsky.feed.repost":
-		return p.handleCreateInteraction(ctx, recb, uriFromParts(did, collection, rkey), did, collection, rkey, iat)
+		return p.handleCreateInteraction(ctx, rev, recb, uriFromParts(did, collection, rkey), did, collection, rkey, iat)
+	case "app.bsky.feed.threadgate":
+		return p.handleCreateThreadgate(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.feed.postgate":
+		return p.handleCreatePostgate(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.feed.generator":
+		return p.handleCreateFeedGenerator(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.labeler.service":
+		return p.handleCreateLabelerService(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.actor.profile":
+		return p.handleCreateProfile(ctx, rev, recb, did, rkey, iat)
+	case "app.bsky.graph.block":
+		return p.handleCreateBlock(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.graph.list":
+		return p.handleCreateList(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.graph.listitem":
+		return p.handleCreateListItem(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.graph.listblock":
+		return p.handleCreateListBlock(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
+	case "app.bsky.graph.starterpack":
+		return p.handleCreateStarterPack(ctx, rev, recb, uriFromParts(did, collection, rkey), did, rkey, iat)
 	default:
 		return nil
 	}
 }
 
-func (p *Photocopy) handleCreateRecord(ctx context.Context, did, rkey, collection, cid string, raw []byte, seq string) error {
+func (p *Photocopy) handleCreateRecord(ctx context.Context, did, rkey, collection, cid string, raw []byte, seq, rev string) error {
+	if !p.recordFilter.Allow(did, collection) {
+		return nil
+	}
+
 	var cat time.Time
 	prkey, err := syntax.ParseTID(rkey)
 	if err == nil {
@@ -51,10 +85,25 @@ func (p *Photocopy) handleCreateRecord(ctx context.Context, did, rkey, collectio
 		Collection: collection,
 		Cid:        cid,
 		Seq:        seq,
+		Rev:        rev,
 		Raw:        string(raw),
 		CreatedAt:  cat,
 	}
 
+	if p.recordJSON != RecordJSONOff {
+		j, err := recordJSON(raw)
+		if err != nil {
+			// keep the raw bytes around when we can't render them, even in instead mode
+			decodeFailures.WithLabelValues("record_dag_json").Inc()
+			p.logger.Warn("failed to render record as json", "did", did, "collection", collection, "rkey", rkey, "error", err)
+		} else {
+			rec.Json = j
+			if p.recordJSON == RecordJSONInstead {
+				rec.Raw = ""
+			}
+		}
+	}
+
 	if err := p.inserters.recordsInserter.Insert(ctx, rec); err != nil {
 		return err
 	}
@@ -62,7 +111,7 @@ func (p *Photocopy) handleCreateRecord(ctx context.Context, did, rkey, collectio
 	return nil
 }
 
-func (p *Photocopy) handleCreatePost(ctx context.Context, rev string, recb []byte, uri, did, collection, rkey, cid string, indexedAt time.Time) error {
+func (p *Photocopy) handleCreatePost(ctx context.Context, rev string, recb []byte, uri, did, collection, rkey, cid string, indexedAt time.Time, update bool) error {
 	var rec bsky.FeedPost
 	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
 		return err
@@ -85,7 +134,14 @@ func (p *Photocopy) handleCreatePost(ctx context.Context, rev string, recb []byt
 		IndexedAt: indexedAt,
 		Did:       did,
 		Lang:      lang,
+		Langs:     rec.Langs,
 		Text:      rec.Text,
+		EmbedType: embedType(rec.Embed),
+		Rev:       rev,
+	}
+
+	if post.Langs == nil {
+		post.Langs = []string{}
 	}
 
 	if rec.Reply != nil {
@@ -131,38 +187,179 @@ func (p *Photocopy) handleCreatePost(ctx context.Context, rev string, recb []byt
 		return err
 	}
 
+	if err := p.handleCreatePostFacets(ctx, post, rec.Facets); err != nil {
+		return err
+	}
+
+	if err := p.handleCreatePostMedia(ctx, post, rec.Embed); err != nil {
+		return err
+	}
+
 	isEn := slices.Contains(rec.Langs, "en")
-	if rec.Text != "" && rec.Reply == nil && isEn && p.nervanaClient != nil {
-		go func(ctx context.Context, rec bsky.FeedPost, did, rkey string) {
-			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
-			defer cancel()
+	// the post was already labeled when it was created
+	if !update && rec.Text != "" && rec.Reply == nil && isEn && p.nervanaClient != nil {
+		p.enqueueNervana(nervanaJob{did: did, rkey: rkey, text: rec.Text})
+	}
 
-			nervanaItems, err := p.nervanaClient.MakeRequest(ctx, rec.Text)
-			if err != nil {
-				p.logger.Error("error making nervana items request", "error", err)
-				return
+	return nil
+}
+
+func (p *Photocopy) handleCreatePostFacets(ctx context.Context, post models.Post, facets []*bsky.RichtextFacet) error {
+	for _, f := range facets {
+		if f == nil {
+			continue
+		}
+
+		for _, feat := range f.Features {
+			if feat == nil {
+				continue
+			}
+
+			pf := models.PostFacet{
+				Uri:       post.Uri,
+				Did:       post.Did,
+				Rkey:      post.Rkey,
+				CreatedAt: post.CreatedAt,
+				IndexedAt: post.IndexedAt,
+				Rev:       post.Rev,
 			}
 
-			for _, ni := range nervanaItems {
-				postLabel := models.PostLabel{
-					Did:         did,
-					Rkey:        rkey,
-					Text:        ni.Text,
-					Label:       ni.Label,
-					EntityId:    ni.EntityId,
-					Description: ni.Description,
-					Topic:       "",
-					CreatedAt:   time.Now(),
+			if f.Index != nil {
+				pf.ByteStart = f.Index.ByteStart
+				pf.ByteEnd = f.Index.ByteEnd
+			}
+
+			switch {
+			case feat.RichtextFacet_Mention != nil:
+				pf.Kind = "mention"
+				pf.MentionDid = feat.RichtextFacet_Mention.Did
+			case feat.RichtextFacet_Link != nil:
+				pf.Kind = "link"
+				pf.LinkUri = feat.RichtextFacet_Link.Uri
+				if u, err := url.Parse(pf.LinkUri); err == nil {
+					pf.LinkDomain = strings.ToLower(u.Hostname())
 				}
-				p.inserters.labelsInserter.Insert(ctx, postLabel)
+			case feat.RichtextFacet_Tag != nil:
+				pf.Kind = "tag"
+				pf.Tag = feat.RichtextFacet_Tag.Tag
+			default:
+				continue
+			}
+
+			if err := p.inserters.postFacetsInserter.Insert(ctx, pf); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func embedType(embed *bsky.FeedPost_Embed) string {
+	if embed == nil {
+		return ""
+	}
+
+	switch {
+	case embed.EmbedImages != nil:
+		return "images"
+	case embed.EmbedVideo != nil:
+		return "video"
+	case embed.EmbedExternal != nil:
+		return "external"
+	case embed.EmbedRecord != nil:
+		return "record"
+	case embed.EmbedRecordWithMedia != nil:
+		return "recordWithMedia"
+	default:
+		return ""
+	}
+}
+
+func (p *Photocopy) handleCreatePostMedia(ctx context.Context, post models.Post, embed *bsky.FeedPost_Embed) error {
+	if embed == nil {
+		return nil
+	}
+
+	images, video, external := embed.EmbedImages, embed.EmbedVideo, embed.EmbedExternal
+	if embed.EmbedRecordWithMedia != nil && embed.EmbedRecordWithMedia.Media != nil {
+		media := embed.EmbedRecordWithMedia.Media
+		images, video, external = media.EmbedImages, media.EmbedVideo, media.EmbedExternal
+	}
+
+	base := models.PostMedia{
+		Uri:       post.Uri,
+		Did:       post.Did,
+		Rkey:      post.Rkey,
+		CreatedAt: post.CreatedAt,
+		IndexedAt: post.IndexedAt,
+		Rev:       post.Rev,
+	}
+
+	var items []models.PostMedia
+
+	if images != nil {
+		for i, img := range images.Images {
+			if img == nil {
+				continue
 			}
-		}(ctx, rec, did, rkey)
+
+			pm := base
+			pm.Kind = "image"
+			pm.Position = uint16(i)
+			pm.Alt = img.Alt
+			if img.Image != nil {
+				pm.BlobCid = img.Image.Ref.String()
+				pm.MimeType = img.Image.MimeType
+			}
+			if img.AspectRatio != nil {
+				pm.AspectWidth = img.AspectRatio.Width
+				pm.AspectHeight = img.AspectRatio.Height
+			}
+			items = append(items, pm)
+		}
+	}
+
+	if video != nil {
+		pm := base
+		pm.Kind = "video"
+		if video.Alt != nil {
+			pm.Alt = *video.Alt
+		}
+		if video.Video != nil {
+			pm.BlobCid = video.Video.Ref.String()
+			pm.MimeType = video.Video.MimeType
+		}
+		if video.AspectRatio != nil {
+			pm.AspectWidth = video.AspectRatio.Width
+			pm.AspectHeight = video.AspectRatio.Height
+		}
+		items = append(items, pm)
+	}
+
+	if external != nil && external.External != nil {
+		pm := base
+		pm.Kind = "external"
+		pm.ExternalUri = external.External.Uri
+		pm.ExternalTitle = external.External.Title
+		pm.ExternalDescription = external.External.Description
+		if external.External.Thumb != nil {
+			pm.BlobCid = external.External.Thumb.Ref.String()
+			pm.MimeType = external.External.Thumb.MimeType
+		}
+		items = append(items, pm)
+	}
+
+	for _, pm := range items {
+		if err := p.inserters.postMediaInserter.Insert(ctx, pm); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (p *Photocopy) handleCreateFollow(ctx context.Context, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+func (p *Photocopy) handleCreateFollow(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
 	var rec bsky.GraphFollow
 	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
 		return err
@@ -180,6 +377,7 @@ func (p *Photocopy) handleCreateFollow(ctx context.Context, recb []byte, uri, di
 		CreatedAt: *cat,
 		IndexedAt: indexedAt,
 		Subject:   rec.Subject,
+		Rev:       rev,
 	}
 
 	if err := p.inserters.followsInserter.Insert(ctx, follow); err != nil {
@@ -189,7 +387,7 @@ func (p *Photocopy) handleCreateFollow(ctx context.Context, recb []byte, uri, di
 	return nil
 }
 
-func (p *Photocopy) handleCreateInteraction(ctx context.Context, recb []byte, uri, did, collection, rkey string, indexedAt time.Time) error {
+func (p *Photocopy) handleCreateInteraction(ctx context.Context, rev string, recb []byte, uri, did, collection, rkey string, indexedAt time.Time) error {
 	colPts := strings.Split(collection, ".")
 	if len(colPts) < 4 {
 		return fmt.Errorf("invalid collection type %s", collection)
@@ -203,6 +401,7 @@ func (p *Photocopy) handleCreateInteraction(ctx context.Context, recb []byte, ur
 		Did:        did,
 		SubjectUri: uri,
 		SubjectDid: did,
+		Rev:        rev,
 	}
 
 	switch collection {
@@ -230,6 +429,8 @@ func (p *Photocopy) handleCreateInteraction(ctx context.Context, recb []byte, ur
 		interaction.SubjectDid = aturi.Authority().String()
 		interaction.SubjectUri = rec.Subject.Uri
 		interaction.CreatedAt = *cat
+
+		interaction.ViaUri, interaction.ViaDid = viaFromRef(rec.Via)
 	case "app.bsky.feed.repost":
 		var rec bsky.FeedRepost
 		if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
@@ -254,6 +455,8 @@ func (p *Photocopy) handleCreateInteraction(ctx context.Context, recb []byte, ur
 		interaction.SubjectDid = aturi.Authority().String()
 		interaction.SubjectUri = rec.Subject.Uri
 		interaction.CreatedAt = *cat
+
+		interaction.ViaUri, interaction.ViaDid = viaFromRef(rec.Via)
 	}
 
 	if err := p.inserters.interactionsInserter.Insert(ctx, interaction); err != nil {
@@ -263,6 +466,21 @@ func (p *Photocopy) handleCreateInteraction(ctx context.Context, recb []byte, ur
 	return nil
 }
 
+// viaFromRef returns the uri and did of the record an interaction was made through. via is optional
+// metadata, so a missing or malformed ref just leaves both empty rather than dropping the interaction.
+func viaFromRef(ref *atproto.RepoStrongRef) (string, string) {
+	if ref == nil {
+		return "", ""
+	}
+
+	uri, err := syntax.ParseATURI(ref.Uri)
+	if err != nil {
+		return "", ""
+	}
+
+	return ref.Uri, uri.Authority().String()
+}
+
 func parseTimeFromRecord(rec any, rkey string) (*time.Time, error) {
 	var rkeyTime time.Time
 	if rkey != "self" {
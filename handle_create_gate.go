@@ -0,0 +1,120 @@
+package photocopy
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/haileyok/photocopy/models"
+)
+
+func (p *Photocopy) handleCreateThreadgate(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.FeedThreadgate
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	allowAll, err := threadgateAllowsAll(recb)
+	if err != nil {
+		return err
+	}
+
+	tg := models.Threadgate{
+		Uri:           uri,
+		Did:           did,
+		Rkey:          rkey,
+		PostUri:       rec.Post,
+		CreatedAt:     *cat,
+		IndexedAt:     indexedAt,
+		AllowAll:      allowAll,
+		AllowLists:    []string{},
+		HiddenReplies: rec.HiddenReplies,
+		Rev:           rev,
+	}
+
+	if tg.HiddenReplies == nil {
+		tg.HiddenReplies = []string{}
+	}
+
+	for _, rule := range rec.Allow {
+		if rule == nil {
+			continue
+		}
+
+		switch {
+		case rule.FeedThreadgate_MentionRule != nil:
+			tg.AllowMention = true
+		case rule.FeedThreadgate_FollowerRule != nil:
+			tg.AllowFollower = true
+		case rule.FeedThreadgate_FollowingRule != nil:
+			tg.AllowFollowing = true
+		case rule.FeedThreadgate_ListRule != nil:
+			tg.AllowLists = append(tg.AllowLists, rule.FeedThreadgate_ListRule.List)
+		}
+	}
+
+	if err := p.inserters.threadgatesInserter.Insert(ctx, tg); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// threadgateAllowsAll reports whether a threadgate leaves replies open to everyone. An undefined allow
+// list means anyone can reply, while an empty one means no one can, but cbor-gen decodes both to a nil
+// slice, so this checks for the key in the raw record instead.
+func threadgateAllowsAll(recb []byte) (bool, error) {
+	m, err := data.UnmarshalCBOR(recb)
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := m["allow"]
+	return !ok, nil
+}
+
+func (p *Photocopy) handleCreatePostgate(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.FeedPostgate
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	pg := models.Postgate{
+		Uri:                   uri,
+		Did:                   did,
+		Rkey:                  rkey,
+		PostUri:               rec.Post,
+		CreatedAt:             *cat,
+		IndexedAt:             indexedAt,
+		DetachedEmbeddingUris: rec.DetachedEmbeddingUris,
+		Rev:                   rev,
+	}
+
+	if pg.DetachedEmbeddingUris == nil {
+		pg.DetachedEmbeddingUris = []string{}
+	}
+
+	for _, rule := range rec.EmbeddingRules {
+		if rule != nil && rule.FeedPostgate_DisableRule != nil {
+			pg.EmbeddingDisabled = true
+		}
+	}
+
+	if err := p.inserters.postgatesInserter.Insert(ctx, pg); err != nil {
+		return err
+	}
+
+	return nil
+}
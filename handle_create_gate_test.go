@@ -0,0 +1,58 @@
+package photocopy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+)
+
+func TestThreadgateAllowsAll(t *testing.T) {
+	tests := []struct {
+		name  string
+		allow []*bsky.FeedThreadgate_Allow_Elem
+		want  bool
+	}{
+		{
+			name:  "undefined allow list lets anyone reply",
+			allow: nil,
+			want:  true,
+		},
+		{
+			name:  "empty allow list lets no one reply",
+			allow: []*bsky.FeedThreadgate_Allow_Elem{},
+			want:  false,
+		},
+		{
+			name: "rules restrict replies",
+			allow: []*bsky.FeedThreadgate_Allow_Elem{
+				{FeedThreadgate_MentionRule: &bsky.FeedThreadgate_MentionRule{LexiconTypeID: "app.bsky.feed.threadgate#mentionRule"}},
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := bsky.FeedThreadgate{
+				LexiconTypeID: "app.bsky.feed.threadgate",
+				Post:          "at://did:plc:example/app.bsky.feed.post/3kabc",
+				CreatedAt:     "2024-01-01T00:00:00Z",
+				Allow:         tt.allow,
+			}
+
+			var buf bytes.Buffer
+			if err := rec.MarshalCBOR(&buf); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := threadgateAllowsAll(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("threadgateAllowsAll = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package photocopy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/photocopy/models"
+)
+
+func (p *Photocopy) handleCreateBlock(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.GraphBlock
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	block := models.Block{
+		Uri:       uri,
+		Did:       did,
+		Rkey:      rkey,
+		CreatedAt: *cat,
+		IndexedAt: indexedAt,
+		Subject:   rec.Subject,
+		Rev:       rev,
+	}
+
+	if err := p.inserters.blocksInserter.Insert(ctx, block); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Photocopy) handleCreateList(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.GraphList
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	list := models.List{
+		Uri:       uri,
+		Did:       did,
+		Rkey:      rkey,
+		CreatedAt: *cat,
+		IndexedAt: indexedAt,
+		Name:      rec.Name,
+		Rev:       rev,
+	}
+
+	if rec.Purpose != nil {
+		list.Purpose = *rec.Purpose
+	}
+
+	if rec.Description != nil {
+		list.Description = *rec.Description
+	}
+
+	if err := p.inserters.listsInserter.Insert(ctx, list); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Photocopy) handleCreateListItem(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.GraphListitem
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	item := models.ListItem{
+		Uri:       uri,
+		Did:       did,
+		Rkey:      rkey,
+		CreatedAt: *cat,
+		IndexedAt: indexedAt,
+		ListUri:   rec.List,
+		Subject:   rec.Subject,
+		Rev:       rev,
+	}
+
+	if err := p.inserters.listItemsInserter.Insert(ctx, item); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Photocopy) handleCreateListBlock(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.GraphListblock
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	aturi, err := syntax.ParseATURI(rec.Subject)
+	if err != nil {
+		return fmt.Errorf("error parsing at-uri: %w", err)
+	}
+
+	lb := models.ListBlock{
+		Uri:        uri,
+		Did:        did,
+		Rkey:       rkey,
+		CreatedAt:  *cat,
+		IndexedAt:  indexedAt,
+		SubjectUri: rec.Subject,
+		SubjectDid: aturi.Authority().String(),
+		Rev:        rev,
+	}
+
+	if err := p.inserters.listBlocksInserter.Insert(ctx, lb); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Photocopy) handleCreateStarterPack(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.GraphStarterpack
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	sp := models.StarterPack{
+		Uri:       uri,
+		Did:       did,
+		Rkey:      rkey,
+		CreatedAt: *cat,
+		IndexedAt: indexedAt,
+		Name:      rec.Name,
+		ListUri:   rec.List,
+		Feeds:     []string{},
+		Rev:       rev,
+	}
+
+	if rec.Description != nil {
+		sp.Description = *rec.Description
+	}
+
+	for _, f := range rec.Feeds {
+		if f == nil {
+			continue
+		}
+		sp.Feeds = append(sp.Feeds, f.Uri)
+	}
+
+	if err := p.inserters.starterPacksInserter.Insert(ctx, sp); err != nil {
+		return err
+	}
+
+	return nil
+}
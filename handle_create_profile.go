@@ -0,0 +1,91 @@
+package photocopy
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/photocopy/models"
+)
+
+func (p *Photocopy) handleCreateProfile(ctx context.Context, rev string, recb []byte, did, rkey string, indexedAt time.Time) error {
+	// only the self record is the actual profile
+	if rkey != "self" {
+		return nil
+	}
+
+	var rec bsky.ActorProfile
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	profile := models.Profile{
+		Did:       did,
+		Labels:    []string{},
+		IndexedAt: indexedAt,
+		Rev:       rev,
+	}
+
+	if rec.CreatedAt != nil {
+		if t, err := dateparse.ParseAny(*rec.CreatedAt); err == nil && inRange(t) {
+			profile.CreatedAt = t
+		}
+	}
+
+	if profile.CreatedAt.IsZero() {
+		cat, err := parseTimeFromRecord(&rec, rkey)
+		if err != nil {
+			return err
+		}
+		profile.CreatedAt = *cat
+	}
+
+	// revs are tids, so their integer value orders versions of the profile
+	if tid, err := syntax.ParseTID(rev); err == nil {
+		profile.Version = tid.Integer()
+	} else {
+		profile.Version = uint64(indexedAt.UnixMicro())
+	}
+
+	if rec.DisplayName != nil {
+		profile.DisplayName = *rec.DisplayName
+	}
+
+	if rec.Description != nil {
+		profile.Description = *rec.Description
+	}
+
+	if rec.Avatar != nil {
+		profile.AvatarCid = rec.Avatar.Ref.String()
+	}
+
+	if rec.Banner != nil {
+		profile.BannerCid = rec.Banner.Ref.String()
+	}
+
+	if rec.Labels != nil && rec.Labels.LabelDefs_SelfLabels != nil {
+		for _, l := range rec.Labels.LabelDefs_SelfLabels.Values {
+			if l == nil {
+				continue
+			}
+			profile.Labels = append(profile.Labels, l.Val)
+		}
+	}
+
+	if rec.PinnedPost != nil {
+		profile.PinnedPostUri = rec.PinnedPost.Uri
+	}
+
+	if rec.JoinedViaStarterPack != nil {
+		profile.JoinedViaStarterPack = rec.JoinedViaStarterPack.Uri
+	}
+
+	if err := p.inserters.profilesInserter.Insert(ctx, profile); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package photocopy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/bsky"
+	"github.com/haileyok/photocopy/models"
+)
+
+func (p *Photocopy) handleCreateFeedGenerator(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.FeedGenerator
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	fg := models.FeedGenerator{
+		Uri:         uri,
+		Did:         did,
+		Rkey:        rkey,
+		CreatedAt:   *cat,
+		IndexedAt:   indexedAt,
+		ServiceDid:  rec.Did,
+		DisplayName: rec.DisplayName,
+		Rev:         rev,
+	}
+
+	if rec.Description != nil {
+		fg.Description = *rec.Description
+	}
+
+	if rec.Avatar != nil {
+		fg.AvatarCid = rec.Avatar.Ref.String()
+	}
+
+	if rec.AcceptsInteractions != nil {
+		fg.AcceptsInteractions = *rec.AcceptsInteractions
+	}
+
+	if rec.ContentMode != nil {
+		fg.ContentMode = *rec.ContentMode
+	}
+
+	if err := p.inserters.feedGeneratorsInserter.Insert(ctx, fg); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Photocopy) handleCreateLabelerService(ctx context.Context, rev string, recb []byte, uri, did, rkey string, indexedAt time.Time) error {
+	var rec bsky.LabelerService
+	if err := rec.UnmarshalCBOR(bytes.NewReader(recb)); err != nil {
+		return err
+	}
+
+	cat, err := parseTimeFromRecord(&rec, rkey)
+	if err != nil {
+		return err
+	}
+
+	ls := models.LabelerService{
+		Uri:                   uri,
+		Did:                   did,
+		Rkey:                  rkey,
+		CreatedAt:             *cat,
+		IndexedAt:             indexedAt,
+		LabelValues:           []string{},
+		LabelValueDefinitions: "[]",
+		ReasonTypes:           derefStrings(rec.ReasonTypes),
+		SubjectTypes:          derefStrings(rec.SubjectTypes),
+		SubjectCollections:    rec.SubjectCollections,
+		Rev:                   rev,
+	}
+
+	if ls.SubjectCollections == nil {
+		ls.SubjectCollections = []string{}
+	}
+
+	if rec.Policies != nil {
+		ls.LabelValues = derefStrings(rec.Policies.LabelValues)
+
+		if len(rec.Policies.LabelValueDefinitions) != 0 {
+			b, err := json.Marshal(rec.Policies.LabelValueDefinitions)
+			if err != nil {
+				return fmt.Errorf("error marshaling label value definitions: %w", err)
+			}
+			ls.LabelValueDefinitions = string(b)
+		}
+	}
+
+	if err := p.inserters.labelerServicesInserter.Insert(ctx, ls); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func derefStrings(strs []*string) []string {
+	out := make([]string, 0, len(strs))
+	for _, s := range strs {
+		if s != nil {
+			out = append(out, *s)
+		}
+	}
+	return out
+}
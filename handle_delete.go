@@ -7,16 +7,27 @@ import (
 	"github.com/haileyok/photocopy/models"
 )
 
-func (p *Photocopy) handleDelete(ctx context.Context, did, collection, rkey string) error {
+func (p *Photocopy) handleDelete(ctx context.Context, did, collection, rkey, rev, seq string) error {
+	// a delete is relevant as long as we might have stored the record in either place
+	if !p.wantsRecord(did, collection) {
+		return nil
+	}
+
 	del := models.Delete{
-		Did:       did,
-		Rkey:      rkey,
-		CreatedAt: time.Now(),
+		Did:        did,
+		Rkey:       rkey,
+		Collection: collection,
+		Uri:        uriFromParts(did, collection, rkey),
+		Seq:        seq,
+		Rev:        rev,
+		CreatedAt:  time.Now(),
 	}
 
 	if err := p.inserters.deletesInserter.Insert(ctx, del); err != nil {
 		return err
 	}
 
+	p.enqueueCascade(did, collection, rkey, rev)
+
 	return nil
 }
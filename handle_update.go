@@ -0,0 +1,15 @@
+package photocopy
+
+import (
+	"context"
+)
+
+// Updates are written exactly like creates. Every row carries the rev of the commit that produced it,
+// so the current state of a record is whichever row has the highest rev for its uri, i.e.
+// argMax(..., rev). rev is a string, so it can't be a ReplacingMergeTree version column; profile
+// derives a numeric version from it for that. post_facet and post_media rows are written again for
+// each version of a post, so they should be joined on the post's latest (uri, rev). Nervana labeling
+// is only done on create.
+func (p *Photocopy) handleUpdate(ctx context.Context, recb []byte, indexedAt, rev, did, collection, rkey, cid, seq string) error {
+	return p.handleRecord(ctx, recb, indexedAt, rev, did, collection, rkey, cid, seq, true)
+}
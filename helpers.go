@@ -1,5 +1,44 @@
 package photocopy
 
+import (
+	"os"
+	"path/filepath"
+)
+
 func uriFromParts(did string, collection string, rkey string) string {
 	return "at://" + did + "/" + collection + "/" + rkey
 }
+
+// writeFileAtomic writes to a temp file in the same directory and renames it into place, so a crash
+// mid-write never leaves a truncated file behind.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,175 @@
+package photocopy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/data"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	SourceFirehose  = "firehose"
+	SourceJetstream = "jetstream"
+)
+
+// jetstream sends a message at least every few seconds on a healthy connection, so anything this long
+// means the connection is dead
+const jetstreamReadTimeout = 1 * time.Minute
+
+type jetstreamEvent struct {
+	Did      string                               `json:"did"`
+	TimeUS   int64                                `json:"time_us"`
+	Kind     string                               `json:"kind"`
+	Commit   *jetstreamCommit                     `json:"commit,omitempty"`
+	Identity *atproto.SyncSubscribeRepos_Identity `json:"identity,omitempty"`
+	Account  *atproto.SyncSubscribeRepos_Account  `json:"account,omitempty"`
+}
+
+type jetstreamCommit struct {
+	Rev        string          `json:"rev"`
+	Operation  string          `json:"operation"`
+	Collection string          `json:"collection"`
+	Rkey       string          `json:"rkey"`
+	Record     json.RawMessage `json:"record,omitempty"`
+	Cid        string          `json:"cid"`
+}
+
+func (p *Photocopy) handleJetstream(ctx context.Context, r *relay, con *websocket.Conn) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			con.Close()
+		case <-done:
+			con.Close()
+		}
+	}()
+
+	for {
+		if err := con.SetReadDeadline(time.Now().Add(jetstreamReadTimeout)); err != nil {
+			return err
+		}
+
+		_, msg, err := con.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("con err at read: %w", err)
+		}
+
+		var evt jetstreamEvent
+		if err := json.Unmarshal(msg, &evt); err != nil {
+			p.logger.Error("failed to decode jetstream event", "error", err)
+			continue
+		}
+
+		r.observe(evt.TimeUS, time.UnixMicro(evt.TimeUS).UTC().Format(time.RFC3339Nano))
+
+		// jetstream cursors are in microseconds rather than relay seqs, but they're just as monotonic
+		r.cursor.begin(evt.TimeUS)
+		if err := p.pool.submit(ctx, evt.Did, func() {
+			defer r.cursor.done(evt.TimeUS)
+			p.jetstreamEvent(ctx, r, &evt)
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Photocopy) jetstreamEvent(ctx context.Context, r *relay, evt *jetstreamEvent) {
+	switch evt.Kind {
+	case "commit":
+		if evt.Commit == nil {
+			return
+		}
+		key := evt.Did + "|" + evt.Commit.Rev + "|" + evt.Commit.Collection + "/" + evt.Commit.Rkey
+		if p.dedup.checkAndMark(key) {
+			relayDuplicates.WithLabelValues(r.host).Inc()
+			return
+		}
+		if err := p.jetstreamCommit(ctx, evt); err != nil {
+			p.logger.Error("error handling jetstream commit", "error", err, "did", evt.Did, "operation", evt.Commit.Operation)
+			p.dedup.unmark(key)
+		}
+	case "identity":
+		if evt.Identity == nil {
+			return
+		}
+		key := "identity|" + evt.Identity.Did + "|" + evt.Identity.Time
+		if p.dedup.checkAndMark(key) {
+			relayDuplicates.WithLabelValues(r.host).Inc()
+			return
+		}
+		var handle string
+		if evt.Identity.Handle != nil {
+			handle = *evt.Identity.Handle
+		}
+		if err := p.handleIdentity(ctx, evt.Identity.Did, handle, evt.Identity.Seq, evt.Identity.Time); err != nil {
+			p.logger.Error("error handling identity event", "error", err)
+			p.dedup.unmark(key)
+		}
+	case "account":
+		if evt.Account == nil {
+			return
+		}
+		key := "account|" + evt.Account.Did + "|" + evt.Account.Time
+		if p.dedup.checkAndMark(key) {
+			relayDuplicates.WithLabelValues(r.host).Inc()
+			return
+		}
+		var status string
+		if evt.Account.Status != nil {
+			status = *evt.Account.Status
+		}
+		if err := p.handleAccount(ctx, evt.Account.Did, evt.Account.Active, status, evt.Account.Seq, evt.Account.Time); err != nil {
+			p.logger.Error("error handling account event", "error", err)
+			p.dedup.unmark(key)
+		}
+	}
+}
+
+func (p *Photocopy) jetstreamCommit(ctx context.Context, evt *jetstreamEvent) error {
+	c := evt.Commit
+	evtTime := time.UnixMicro(evt.TimeUS).UTC().Format(time.RFC3339Nano)
+
+	opsProcessed.WithLabelValues(c.Operation, collectionLabel(c.Collection)).Inc()
+	defer func() {
+		ingestLag.Set(time.Since(time.UnixMicro(evt.TimeUS)).Seconds())
+	}()
+
+	switch c.Operation {
+	case "create", "update":
+		if !p.wantsRecord(evt.Did, c.Collection) {
+			return nil
+		}
+
+		// re-encode as dag-cbor so the records go through the same decoding as the firehose
+		obj, err := data.UnmarshalJSON(c.Record)
+		if err != nil {
+			decodeFailures.WithLabelValues("record_json").Inc()
+			return fmt.Errorf("failed to parse record json: %w", err)
+		}
+
+		recb, err := data.MarshalCBOR(obj)
+		if err != nil {
+			return fmt.Errorf("failed to encode record as cbor: %w", err)
+		}
+
+		// jetstream doesn't pass through the relay seq, so there isn't one to record
+		if c.Operation == "update" {
+			return p.handleUpdate(ctx, recb, evtTime, c.Rev, evt.Did, c.Collection, c.Rkey, c.Cid, "")
+		}
+		return p.handleCreate(ctx, recb, evtTime, c.Rev, evt.Did, c.Collection, c.Rkey, c.Cid, "")
+	case "delete":
+		return p.handleDelete(ctx, evt.Did, c.Collection, c.Rkey, c.Rev, "")
+	default:
+		return fmt.Errorf("unknown jetstream operation %s", c.Operation)
+	}
+}
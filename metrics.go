@@ -0,0 +1,173 @@
+package photocopy
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var relayReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_relay_reconnects_total",
+	Help: "total number of times the relay connection was re-established",
+}, []string{"relay"})
+
+var relayConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "photocopy_relay_connected",
+	Help: "whether photocopy currently has an open connection to the relay",
+}, []string{"relay"})
+
+var relayActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "photocopy_relay_active",
+	Help: "whether the relay is currently being consumed from, as opposed to waiting as a standby",
+}, []string{"relay"})
+
+var relayDowntime = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_relay_downtime_seconds_total",
+	Help: "total seconds spent disconnected from the relay",
+}, []string{"relay"})
+
+var relayOutageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "photocopy_relay_outage_duration_seconds",
+	Help:    "duration of each relay outage, from disconnect until reconnect",
+	Buckets: prometheus.ExponentialBucketsRange(0.1, 600, 15),
+}, []string{"relay"})
+
+var relayEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_relay_events_total",
+	Help: "events received from each relay",
+}, []string{"relay"})
+
+var relayLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "photocopy_relay_lag_seconds",
+	Help: "seconds between the time on the last event from a relay and when we received it",
+}, []string{"relay"})
+
+var relayDuplicates = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_relay_duplicates_total",
+	Help: "events skipped because they were already ingested from another relay",
+}, []string{"relay"})
+
+var relayFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_relay_failovers_total",
+	Help: "times consumption moved from one relay to another",
+}, []string{"from", "to"})
+
+var relayCursorlessFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_relay_cursorless_failovers_total",
+	Help: "failovers onto a relay with no saved cursor, which start at the live tip and miss the events in between",
+}, []string{"relay"})
+
+var relayFailoverGap = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_relay_failover_gap_seconds_total",
+	Help: "seconds of events skipped by failing over onto a relay with no saved cursor, measured from the last event on the previous relay",
+}, []string{"relay"})
+
+var cursorSaved = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "photocopy_cursor_saved_seq",
+	Help: "the firehose seq most recently persisted to the cursor file",
+}, []string{"relay"})
+
+var cursorInflight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "photocopy_cursor_inflight_events",
+	Help: "number of firehose events read but not yet handed to the inserters",
+}, []string{"relay"})
+
+var tooBigCommits = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_too_big_commits_total",
+	Help: "commits flagged as too big and whether their ops could be recovered from the pds (recovered, partial, skipped or unrecoverable)",
+}, []string{"status"})
+
+var verificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_verification_failures_total",
+	Help: "commits that failed verification, by reason",
+}, []string{"reason"})
+
+var verificationRetries = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "photocopy_verification_retries_total",
+	Help: "commit verifications retried because the account's identity could not be resolved",
+})
+
+var workerQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "photocopy_worker_queue_depth",
+	Help: "number of events queued for the commit workers",
+})
+
+var workersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "photocopy_workers_busy",
+	Help: "number of commit workers currently processing an event",
+})
+
+var workerSubmitBlocked = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "photocopy_worker_submit_blocked_seconds_total",
+	Help: "total seconds the stream reader spent waiting on full worker queues",
+})
+
+var nervanaQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "photocopy_nervana_queue_depth",
+	Help: "number of posts waiting for a nervana lookup",
+})
+
+var nervanaDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "photocopy_nervana_dropped_total",
+	Help: "posts skipped for nervana lookup because the queue was full",
+})
+
+var relaySeq = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "photocopy_relay_seq",
+	Help: "the seq of the most recent event received from each relay (time_us for jetstream)",
+}, []string{"relay"})
+
+var ingestLag = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "photocopy_ingest_lag_seconds",
+	Help: "seconds between the time on the most recently processed event and when it finished processing",
+})
+
+var opsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_ops_total",
+	Help: "repo ops processed by action and collection",
+}, []string{"action", "collection"})
+
+var decodeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_decode_failures_total",
+	Help: "events or ops that could not be decoded, by reason",
+}, []string{"reason"})
+
+var reccidMismatches = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "photocopy_reccid_mismatches_total",
+	Help: "ops whose cid did not match the record found in the commit blocks",
+})
+
+var cascadeQueued = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "photocopy_cascade_queued",
+	Help: "deletes waiting to be cascaded into the typed tables",
+})
+
+var cascadeDeletes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_cascade_deletes_total",
+	Help: "keys cascaded into lightweight deletes on the typed tables, by table and status",
+}, []string{"table", "status"})
+
+var purgesQueued = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "photocopy_purges_queued",
+	Help: "deleted accounts waiting to be purged",
+})
+
+var purgedRows = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "photocopy_purged_rows_total",
+	Help: "rows removed by account purges, by table",
+}, []string{"table"})
+
+var captureUnreadableFrames = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "photocopy_capture_unreadable_frames_total",
+	Help: "captured frames whose seq could not be read, stored under the previous seq",
+})
+
+// collectionLabel keeps the collection label to a bounded set, since anyone can publish records under
+// any nsid.
+func collectionLabel(collection string) string {
+	if strings.HasPrefix(collection, "app.bsky.") || strings.HasPrefix(collection, "chat.bsky.") {
+		return collection
+	}
+	return "other"
+}
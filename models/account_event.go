@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type AccountEvent struct {
+	Did       string    `ch:"did"`
+	Seq       int64     `ch:"seq"`
+	Active    bool      `ch:"active"`
+	Status    string    `ch:"status"`
+	Time      time.Time `ch:"time"`
+	IndexedAt time.Time `ch:"indexed_at"`
+}
@@ -3,7 +3,11 @@ package models
 import "time"
 
 type Delete struct {
-	Did       string    `ch:"did"`
-	Rkey      string    `ch:"rkey"`
-	CreatedAt time.Time `ch:"created_at"`
+	Did        string    `ch:"did"`
+	Rkey       string    `ch:"rkey"`
+	Collection string    `ch:"collection"`
+	Uri        string    `ch:"uri"`
+	Seq        string    `ch:"seq"`
+	Rev        string    `ch:"rev"`
+	CreatedAt  time.Time `ch:"created_at"`
 }
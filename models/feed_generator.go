@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type FeedGenerator struct {
+	Uri                 string    `ch:"uri"`
+	Did                 string    `ch:"did"`
+	Rkey                string    `ch:"rkey"`
+	CreatedAt           time.Time `ch:"created_at"`
+	IndexedAt           time.Time `ch:"indexed_at"`
+	ServiceDid          string    `ch:"service_did"`
+	DisplayName         string    `ch:"display_name"`
+	Description         string    `ch:"description"`
+	AvatarCid           string    `ch:"avatar_cid"`
+	AcceptsInteractions bool      `ch:"accepts_interactions"`
+	ContentMode         string    `ch:"content_mode"`
+	Rev                 string    `ch:"rev"`
+}
@@ -9,4 +9,5 @@ type Follow struct {
 	CreatedAt time.Time `ch:"created_at"`
 	IndexedAt time.Time `ch:"indexed_at"`
 	Subject   string    `ch:"subject"`
+	Rev       string    `ch:"rev"`
 }
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type IdentityEvent struct {
+	Did       string    `ch:"did"`
+	Seq       int64     `ch:"seq"`
+	Handle    string    `ch:"handle"`
+	Time      time.Time `ch:"time"`
+	IndexedAt time.Time `ch:"indexed_at"`
+}
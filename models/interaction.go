@@ -11,4 +11,7 @@ type Interaction struct {
 	IndexedAt  time.Time `ch:"indexed_at"`
 	SubjectUri string    `ch:"subject_uri"`
 	SubjectDid string    `ch:"subject_did"`
+	ViaUri     string    `ch:"via_uri"`
+	ViaDid     string    `ch:"via_did"`
+	Rev        string    `ch:"rev"`
 }
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type LabelerService struct {
+	Uri                   string    `ch:"uri"`
+	Did                   string    `ch:"did"`
+	Rkey                  string    `ch:"rkey"`
+	CreatedAt             time.Time `ch:"created_at"`
+	IndexedAt             time.Time `ch:"indexed_at"`
+	LabelValues           []string  `ch:"label_values"`
+	LabelValueDefinitions string    `ch:"label_value_definitions"`
+	ReasonTypes           []string  `ch:"reason_types"`
+	SubjectTypes          []string  `ch:"subject_types"`
+	SubjectCollections    []string  `ch:"subject_collections"`
+	Rev                   string    `ch:"rev"`
+}
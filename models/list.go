@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+type List struct {
+	Uri         string    `ch:"uri"`
+	Did         string    `ch:"did"`
+	Rkey        string    `ch:"rkey"`
+	CreatedAt   time.Time `ch:"created_at"`
+	IndexedAt   time.Time `ch:"indexed_at"`
+	Name        string    `ch:"name"`
+	Purpose     string    `ch:"purpose"`
+	Description string    `ch:"description"`
+	Rev         string    `ch:"rev"`
+}
+
+type ListItem struct {
+	Uri       string    `ch:"uri"`
+	Did       string    `ch:"did"`
+	Rkey      string    `ch:"rkey"`
+	CreatedAt time.Time `ch:"created_at"`
+	IndexedAt time.Time `ch:"indexed_at"`
+	ListUri   string    `ch:"list_uri"`
+	Subject   string    `ch:"subject"`
+	Rev       string    `ch:"rev"`
+}
+
+type ListBlock struct {
+	Uri        string    `ch:"uri"`
+	Did        string    `ch:"did"`
+	Rkey       string    `ch:"rkey"`
+	CreatedAt  time.Time `ch:"created_at"`
+	IndexedAt  time.Time `ch:"indexed_at"`
+	SubjectUri string    `ch:"subject_uri"`
+	SubjectDid string    `ch:"subject_did"`
+	Rev        string    `ch:"rev"`
+}
@@ -15,5 +15,8 @@ type Post struct {
 	QuoteUri  string    `ch:"quote_uri"`
 	QuoteDid  string    `ch:"quote_did"`
 	Lang      string    `ch:"lang"`
+	Langs     []string  `ch:"langs"`
 	Text      string    `ch:"text"`
+	EmbedType string    `ch:"embed_type"`
+	Rev       string    `ch:"rev"`
 }
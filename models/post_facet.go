@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type PostFacet struct {
+	Uri        string    `ch:"uri"`
+	Did        string    `ch:"did"`
+	Rkey       string    `ch:"rkey"`
+	CreatedAt  time.Time `ch:"created_at"`
+	IndexedAt  time.Time `ch:"indexed_at"`
+	Kind       string    `ch:"kind"`
+	ByteStart  int64     `ch:"byte_start"`
+	ByteEnd    int64     `ch:"byte_end"`
+	MentionDid string    `ch:"mention_did"`
+	LinkUri    string    `ch:"link_uri"`
+	LinkDomain string    `ch:"link_domain"`
+	Tag        string    `ch:"tag"`
+	Rev        string    `ch:"rev"`
+}
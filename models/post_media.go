@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type PostMedia struct {
+	Uri                 string    `ch:"uri"`
+	Did                 string    `ch:"did"`
+	Rkey                string    `ch:"rkey"`
+	CreatedAt           time.Time `ch:"created_at"`
+	IndexedAt           time.Time `ch:"indexed_at"`
+	Kind                string    `ch:"kind"`
+	Position            uint16    `ch:"position"`
+	BlobCid             string    `ch:"blob_cid"`
+	MimeType            string    `ch:"mime_type"`
+	Alt                 string    `ch:"alt"`
+	AspectWidth         int64     `ch:"aspect_width"`
+	AspectHeight        int64     `ch:"aspect_height"`
+	ExternalUri         string    `ch:"external_uri"`
+	ExternalTitle       string    `ch:"external_title"`
+	ExternalDescription string    `ch:"external_description"`
+	Rev                 string    `ch:"rev"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type Postgate struct {
+	Uri                   string    `ch:"uri"`
+	Did                   string    `ch:"did"`
+	Rkey                  string    `ch:"rkey"`
+	PostUri               string    `ch:"post_uri"`
+	CreatedAt             time.Time `ch:"created_at"`
+	IndexedAt             time.Time `ch:"indexed_at"`
+	DetachedEmbeddingUris []string  `ch:"detached_embedding_uris"`
+	EmbeddingDisabled     bool      `ch:"embedding_disabled"`
+	Rev                   string    `ch:"rev"`
+}
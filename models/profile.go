@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// profile is a ReplacingMergeTree keyed by did with version as the version column,
+// so the latest rev for each did wins once parts are merged (or with FINAL)
+type Profile struct {
+	Did                  string    `ch:"did"`
+	DisplayName          string    `ch:"display_name"`
+	Description          string    `ch:"description"`
+	AvatarCid            string    `ch:"avatar_cid"`
+	BannerCid            string    `ch:"banner_cid"`
+	Labels               []string  `ch:"labels"`
+	PinnedPostUri        string    `ch:"pinned_post_uri"`
+	JoinedViaStarterPack string    `ch:"joined_via_starter_pack"`
+	CreatedAt            time.Time `ch:"created_at"`
+	IndexedAt            time.Time `ch:"indexed_at"`
+	Rev                  string    `ch:"rev"`
+	Version              uint64    `ch:"version"`
+}
@@ -8,6 +8,8 @@ type Record struct {
 	Collection string    `ch:"collection"`
 	Cid        string    `ch:"cid"`
 	Seq        string    `ch:"seq"`
+	Rev        string    `ch:"rev"`
 	Raw        string    `ch:"raw"`
+	Json       string    `ch:"json"`
 	CreatedAt  time.Time `ch:"created_at"`
 }
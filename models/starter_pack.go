@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type StarterPack struct {
+	Uri         string    `ch:"uri"`
+	Did         string    `ch:"did"`
+	Rkey        string    `ch:"rkey"`
+	CreatedAt   time.Time `ch:"created_at"`
+	IndexedAt   time.Time `ch:"indexed_at"`
+	Name        string    `ch:"name"`
+	Description string    `ch:"description"`
+	ListUri     string    `ch:"list_uri"`
+	Feeds       []string  `ch:"feeds"`
+	Rev         string    `ch:"rev"`
+}
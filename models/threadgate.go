@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type Threadgate struct {
+	Uri            string    `ch:"uri"`
+	Did            string    `ch:"did"`
+	Rkey           string    `ch:"rkey"`
+	PostUri        string    `ch:"post_uri"`
+	CreatedAt      time.Time `ch:"created_at"`
+	IndexedAt      time.Time `ch:"indexed_at"`
+	AllowAll       bool      `ch:"allow_all"`
+	AllowMention   bool      `ch:"allow_mention"`
+	AllowFollower  bool      `ch:"allow_follower"`
+	AllowFollowing bool      `ch:"allow_following"`
+	AllowLists     []string  `ch:"allow_lists"`
+	HiddenReplies  []string  `ch:"hidden_replies"`
+	Rev            string    `ch:"rev"`
+}
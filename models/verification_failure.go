@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+type VerificationFailure struct {
+	Did       string    `ch:"did"`
+	Seq       int64     `ch:"seq"`
+	Rev       string    `ch:"rev"`
+	Commit    string    `ch:"commit"`
+	Reason    string    `ch:"reason"`
+	Error     string    `ch:"error"`
+	Blocks    string    `ch:"blocks"`
+	Time      time.Time `ch:"time"`
+	IndexedAt time.Time `ch:"indexed_at"`
+}
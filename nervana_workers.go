@@ -0,0 +1,71 @@
+package photocopy
+
+import (
+	"context"
+	"time"
+
+	"github.com/haileyok/photocopy/models"
+)
+
+type nervanaJob struct {
+	did  string
+	rkey string
+	text string
+}
+
+// Nervana labels are best effort, so rather than hold up ingestion when the lookups can't keep up we
+// drop posts once the queue is full.
+func (p *Photocopy) enqueueNervana(job nervanaJob) {
+	p.nervanaInflight.Add(1)
+	select {
+	case p.nervanaQueue <- job:
+		nervanaQueueDepth.Inc()
+	default:
+		p.nervanaInflight.Add(-1)
+		nervanaDropped.Inc()
+	}
+}
+
+func (p *Photocopy) runNervanaWorkers(ctx context.Context) {
+	for range max(p.nervanaWorkers, 1) {
+		p.background.Add(1)
+		go func() {
+			defer p.background.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-p.nervanaQueue:
+					nervanaQueueDepth.Dec()
+					p.labelPost(ctx, job)
+					p.nervanaInflight.Add(-1)
+				}
+			}
+		}()
+	}
+}
+
+func (p *Photocopy) labelPost(ctx context.Context, job nervanaJob) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	nervanaItems, err := p.nervanaClient.MakeRequest(ctx, job.text)
+	if err != nil {
+		p.logger.Error("error making nervana items request", "error", err)
+		return
+	}
+
+	for _, ni := range nervanaItems {
+		postLabel := models.PostLabel{
+			Did:         job.did,
+			Rkey:        job.rkey,
+			Text:        ni.Text,
+			Label:       ni.Label,
+			EntityId:    ni.EntityId,
+			Description: ni.Description,
+			Topic:       "",
+			CreatedAt:   time.Now(),
+		}
+		p.inserters.labelsInserter.Insert(ctx, postLabel)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"log/slog"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/haileyok/photocopy/clickhouse_inserter"
 	"github.com/haileyok/photocopy/nervana"
 	"github.com/prometheus/client_golang/prometheus"
@@ -21,10 +23,13 @@ type Photocopy struct {
 	logger *slog.Logger
 	wg     sync.WaitGroup
 
-	relayHost   string
-	cursor      string
-	cursorFile  string
-	metricsAddr string
+	relays       []*relay
+	relayMode    string
+	stallTimeout time.Duration
+	dedup        *dedupCache
+	source       string
+	cursorFile   string
+	metricsAddr  string
 
 	inserters *Inserters
 
@@ -37,6 +42,33 @@ type Photocopy struct {
 	nervanaClient   *nervana.Client
 	nervanaEndpoint string
 	nervanaApiKey   string
+	nervanaQueue    chan nervanaJob
+	nervanaWorkers  int
+
+	pool *workerPool
+
+	recordFilter *Filter
+	typedFilter  *Filter
+
+	dir            identity.Directory
+	repoDownloader *RepoDownloader
+	tooBigQueue    chan tooBigJob
+
+	// jobs handed off from the worker pool that haven't finished yet, so a replay knows when it is done
+	tooBigInflight  atomic.Int64
+	nervanaInflight atomic.Int64
+	// the too big and nervana workers, so shutdown can wait for them before closing the inserters
+	background sync.WaitGroup
+
+	verifyCommits bool
+
+	jetstreamCollections []string
+
+	recordJSON string
+
+	cascader *cascader
+
+	purger *purger
 }
 
 type Inserters struct {
@@ -47,11 +79,31 @@ type Inserters struct {
 	recordsInserter      *clickhouse_inserter.Inserter
 	deletesInserter      *clickhouse_inserter.Inserter
 	labelsInserter       *clickhouse_inserter.Inserter
+	identitiesInserter   *clickhouse_inserter.Inserter
+	accountsInserter     *clickhouse_inserter.Inserter
+
+	verificationFailuresInserter *clickhouse_inserter.Inserter
+
+	blocksInserter       *clickhouse_inserter.Inserter
+	listsInserter        *clickhouse_inserter.Inserter
+	listItemsInserter    *clickhouse_inserter.Inserter
+	listBlocksInserter   *clickhouse_inserter.Inserter
+	starterPacksInserter *clickhouse_inserter.Inserter
+	profilesInserter     *clickhouse_inserter.Inserter
+	postFacetsInserter   *clickhouse_inserter.Inserter
+	postMediaInserter    *clickhouse_inserter.Inserter
+	threadgatesInserter  *clickhouse_inserter.Inserter
+	postgatesInserter    *clickhouse_inserter.Inserter
+
+	feedGeneratorsInserter  *clickhouse_inserter.Inserter
+	labelerServicesInserter *clickhouse_inserter.Inserter
 }
 
 type Args struct {
 	Logger               *slog.Logger
-	RelayHost            string
+	RelayHosts           []string
+	RelayMode            string
+	RelayStallTimeout    time.Duration
 	MetricsAddr          string
 	CursorFile           string
 	PLCScraperCursorFile string
@@ -62,29 +114,119 @@ type Args struct {
 	RatelimitBypassKey   string
 	NervanaEndpoint      string
 	NervanaApiKey        string
+	VerifyCommits        bool
+	Source               string
+	JetstreamCollections []string
+	Workers              int
+	WorkerQueueSize      int
+	NervanaWorkers       int
+	RecordFilter         FilterArgs
+	TypedFilter          FilterArgs
+	RecordJSON           string
+	DeleteCascade        bool
+	PurgeDeletedAccounts bool
+	InsertFlushInterval  time.Duration
+	InsertMaxRetries     int
+	InsertRetryBackoff   time.Duration
+	DeadLetterDir        string
+	InsertSendWorkers    int
 }
 
 func New(ctx context.Context, args *Args) (*Photocopy, error) {
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: []string{args.ClickhouseAddr},
-		Auth: clickhouse.Auth{
-			Database: args.ClickhouseDatabase,
-			Username: args.ClickhouseUser,
-			Password: args.ClickhousePass,
-		},
-	})
+	if len(args.RelayHosts) == 0 {
+		return nil, fmt.Errorf("at least one relay host is required")
+	}
+
+	switch args.RelayMode {
+	case "":
+		args.RelayMode = RelayModeParallel
+	case RelayModeParallel, RelayModeFailover:
+	default:
+		return nil, fmt.Errorf("unknown relay mode %q", args.RelayMode)
+	}
+
+	if args.RelayStallTimeout == 0 {
+		args.RelayStallTimeout = 2 * time.Minute
+	}
+
+	switch args.Source {
+	case "":
+		args.Source = SourceFirehose
+	case SourceFirehose, SourceJetstream:
+	default:
+		return nil, fmt.Errorf("unknown source %q", args.Source)
+	}
+
+	switch args.RecordJSON {
+	case "":
+		args.RecordJSON = RecordJSONOff
+	case RecordJSONOff, RecordJSONAlso, RecordJSONInstead:
+	default:
+		return nil, fmt.Errorf("unknown record json mode %q", args.RecordJSON)
+	}
+
+	recordFilter, err := NewFilter(args.RecordFilter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record filter: %w", err)
+	}
+
+	typedFilter, err := NewFilter(args.TypedFilter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid typed filter: %w", err)
+	}
+
+	conn, err := OpenClickhouse(args.ClickhouseAddr, args.ClickhouseDatabase, args.ClickhouseUser, args.ClickhousePass)
 	if err != nil {
 		return nil, err
 	}
 
 	p := &Photocopy{
-		logger:             args.Logger,
-		metricsAddr:        args.MetricsAddr,
-		relayHost:          args.RelayHost,
-		wg:                 sync.WaitGroup{},
-		cursorFile:         args.CursorFile,
-		ratelimitBypassKey: args.RatelimitBypassKey,
-		conn:               conn,
+		logger:               args.Logger,
+		metricsAddr:          args.MetricsAddr,
+		relays:               newRelays(args.RelayHosts, args.CursorFile),
+		relayMode:            args.RelayMode,
+		stallTimeout:         args.RelayStallTimeout,
+		source:               args.Source,
+		wg:                   sync.WaitGroup{},
+		cursorFile:           args.CursorFile,
+		ratelimitBypassKey:   args.RatelimitBypassKey,
+		conn:                 conn,
+		dir:                  identity.DefaultDirectory(),
+		tooBigQueue:          make(chan tooBigJob, 1000),
+		verifyCommits:        args.VerifyCommits,
+		jetstreamCollections: args.JetstreamCollections,
+		pool:                 newWorkerPool(args.Workers, args.WorkerQueueSize),
+		nervanaWorkers:       args.NervanaWorkers,
+		nervanaQueue:         make(chan nervanaJob, 1000),
+		recordFilter:         recordFilter,
+		typedFilter:          typedFilter,
+		recordJSON:           args.RecordJSON,
+	}
+
+	p.repoDownloader = NewRepoDownloader(p)
+
+	if args.DeleteCascade {
+		p.cascader, err = newCascader(args.CursorFile+".cascades", args.InsertFlushInterval, args.InsertMaxRetries, args.InsertRetryBackoff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if args.PurgeDeletedAccounts {
+		p.purger, err = newPurger(args.CursorFile+".purges", args.InsertFlushInterval, args.InsertMaxRetries, args.InsertRetryBackoff)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	recordQuery := "INSERT INTO record (did, rkey, collection, cid, seq, rev, raw, created_at)"
+	if p.recordJSON != RecordJSONOff {
+		recordQuery = "INSERT INTO record (did, rkey, collection, cid, seq, rev, raw, json, created_at)"
+	}
+
+	// only needed when more than one relay could hand us the same event
+	if len(p.relays) > 1 {
+		p.dedup = newDedupCache(1_000_000)
 	}
 
 	insertionsHist := promauto.NewHistogramVec(prometheus.HistogramOpts{
@@ -93,80 +235,128 @@ func New(ctx context.Context, args *Args) (*Photocopy, error) {
 		Buckets: prometheus.ExponentialBucketsRange(0.0001, 30, 20),
 	}, []string{"type"})
 
-	fi, err := clickhouse_inserter.New(ctx, &clickhouse_inserter.Args{
-		PrometheusCounterPrefix: "photocopy_follows",
-		Histogram:               insertionsHist,
-		BatchSize:               500,
-		Logger:                  p.logger,
-		Conn:                    conn,
-		Query:                   "INSERT INTO follow (uri, did, rkey, created_at, indexed_at, subject)",
-		RateLimit:               3,
-	})
+	// everything but the table specific bits is shared by all the inserters
+	baseInserterArgs := clickhouse_inserter.Args{
+		Histogram:     insertionsHist,
+		FlushInterval: args.InsertFlushInterval,
+		MaxRetries:    args.InsertMaxRetries,
+		RetryBackoff:  args.InsertRetryBackoff,
+		DeadLetterDir: args.DeadLetterDir,
+		SendWorkers:   args.InsertSendWorkers,
+		Logger:        p.logger,
+		Conn:          conn,
+		RateLimit:     3,
+	}
+
+	newInserter := func(prefix, query string, batchSize int) (*clickhouse_inserter.Inserter, error) {
+		ia := baseInserterArgs
+		ia.PrometheusCounterPrefix = prefix
+		ia.Query = query
+		ia.BatchSize = batchSize
+		return clickhouse_inserter.New(ctx, &ia)
+	}
+
+	fi, err := newInserter("photocopy_follows", "INSERT INTO follow (uri, did, rkey, created_at, indexed_at, subject, rev)", 500)
 	if err != nil {
 		return nil, err
 	}
 
-	pi, err := clickhouse_inserter.New(ctx, &clickhouse_inserter.Args{
-		PrometheusCounterPrefix: "photocopy_posts",
-		Histogram:               insertionsHist,
-		BatchSize:               300,
-		Logger:                  p.logger,
-		Conn:                    conn,
-		Query:                   "INSERT INTO post (uri, did, rkey, created_at, indexed_at, root_uri, root_did, parent_uri, parent_did, quote_uri, quote_did, lang)",
-		RateLimit:               3,
-	})
+	pi, err := newInserter("photocopy_posts", "INSERT INTO post (uri, did, rkey, created_at, indexed_at, root_uri, root_did, parent_uri, parent_did, quote_uri, quote_did, lang, langs, text, embed_type, rev)", 300)
 	if err != nil {
 		return nil, err
 	}
 
-	ii, err := clickhouse_inserter.New(ctx, &clickhouse_inserter.Args{
-		PrometheusCounterPrefix: "photocopy_interactions",
-		Histogram:               insertionsHist,
-		BatchSize:               1000,
-		Logger:                  p.logger,
-		Conn:                    conn,
-		Query:                   "INSERT INTO interaction (uri, did, rkey, kind, created_at, indexed_at, subject_uri, subject_did)",
-		RateLimit:               3,
-	})
+	ii, err := newInserter("photocopy_interactions", "INSERT INTO interaction (uri, did, rkey, kind, created_at, indexed_at, subject_uri, subject_did, via_uri, via_did, rev)", 1000)
 	if err != nil {
 		return nil, err
 	}
 
-	ri, err := clickhouse_inserter.New(ctx, &clickhouse_inserter.Args{
-		PrometheusCounterPrefix: "photocopy_records",
-		Histogram:               insertionsHist,
-		BatchSize:               2500,
-		Logger:                  p.logger,
-		Conn:                    conn,
-		Query:                   "INSERT INTO record (did, rkey, collection, cid, seq, raw, created_at)",
-		RateLimit:               3,
-	})
+	ri, err := newInserter("photocopy_records", recordQuery, 2500)
 	if err != nil {
 		return nil, err
 	}
 
-	di, err := clickhouse_inserter.New(ctx, &clickhouse_inserter.Args{
-		PrometheusCounterPrefix: "photocopy_deletes",
-		Histogram:               insertionsHist,
-		BatchSize:               500,
-		Logger:                  p.logger,
-		Conn:                    conn,
-		Query:                   "INSERT INTO delete (did, rkey, created_at)",
-		RateLimit:               3,
-	})
+	di, err := newInserter("photocopy_deletes", "INSERT INTO delete (did, rkey, collection, uri, seq, rev, created_at)", 500)
 	if err != nil {
 		return nil, err
 	}
 
-	li, err := clickhouse_inserter.New(ctx, &clickhouse_inserter.Args{
-		PrometheusCounterPrefix: "photocopy_labels",
-		Histogram:               insertionsHist,
-		BatchSize:               100,
-		Logger:                  p.logger,
-		Conn:                    conn,
-		Query:                   "INSERT INTO post_label (did, rkey, text, label, entity_id, description, topic, created_at)",
-		RateLimit:               3,
-	})
+	li, err := newInserter("photocopy_labels", "INSERT INTO post_label (did, rkey, text, label, entity_id, description, topic, created_at)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	idi, err := newInserter("photocopy_identities", "INSERT INTO identity (did, seq, handle, time, indexed_at)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	ai, err := newInserter("photocopy_accounts", "INSERT INTO account (did, seq, active, status, time, indexed_at)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	vfi, err := newInserter("photocopy_verification_failures", "INSERT INTO verification_failure (did, seq, rev, commit, reason, error, blocks, time, indexed_at)", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	bi, err := newInserter("photocopy_blocks", "INSERT INTO block (uri, did, rkey, created_at, indexed_at, subject, rev)", 500)
+	if err != nil {
+		return nil, err
+	}
+
+	lsi, err := newInserter("photocopy_lists", "INSERT INTO list (uri, did, rkey, created_at, indexed_at, name, purpose, description, rev)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	lii, err := newInserter("photocopy_list_items", "INSERT INTO list_item (uri, did, rkey, created_at, indexed_at, list_uri, subject, rev)", 500)
+	if err != nil {
+		return nil, err
+	}
+
+	lbi, err := newInserter("photocopy_list_blocks", "INSERT INTO list_block (uri, did, rkey, created_at, indexed_at, subject_uri, subject_did, rev)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	spi, err := newInserter("photocopy_starter_packs", "INSERT INTO starter_pack (uri, did, rkey, created_at, indexed_at, name, description, list_uri, feeds, rev)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	pri, err := newInserter("photocopy_profiles", "INSERT INTO profile (did, display_name, description, avatar_cid, banner_cid, labels, pinned_post_uri, joined_via_starter_pack, created_at, indexed_at, rev, version)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	pfi, err := newInserter("photocopy_post_facets", "INSERT INTO post_facet (uri, did, rkey, created_at, indexed_at, kind, byte_start, byte_end, mention_did, link_uri, link_domain, tag, rev)", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	pmi, err := newInserter("photocopy_post_media", "INSERT INTO post_media (uri, did, rkey, created_at, indexed_at, kind, position, blob_cid, mime_type, alt, aspect_width, aspect_height, external_uri, external_title, external_description, rev)", 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	tgi, err := newInserter("photocopy_threadgates", "INSERT INTO threadgate (uri, did, rkey, post_uri, created_at, indexed_at, allow_all, allow_mention, allow_follower, allow_following, allow_lists, hidden_replies, rev)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	pgi, err := newInserter("photocopy_postgates", "INSERT INTO postgate (uri, did, rkey, post_uri, created_at, indexed_at, detached_embedding_uris, embedding_disabled, rev)", 100)
+	if err != nil {
+		return nil, err
+	}
+
+	fgi, err := newInserter("photocopy_feed_generators", "INSERT INTO feed_generator (uri, did, rkey, created_at, indexed_at, service_did, display_name, description, avatar_cid, accepts_interactions, content_mode, rev)", 10)
+	if err != nil {
+		return nil, err
+	}
+
+	lsvi, err := newInserter("photocopy_labeler_services", "INSERT INTO labeler_service (uri, did, rkey, created_at, indexed_at, label_values, label_value_definitions, reason_types, subject_types, subject_collections, rev)", 10)
 	if err != nil {
 		return nil, err
 	}
@@ -178,24 +368,42 @@ func New(ctx context.Context, args *Args) (*Photocopy, error) {
 		recordsInserter:      ri,
 		deletesInserter:      di,
 		labelsInserter:       li,
+		identitiesInserter:   idi,
+		accountsInserter:     ai,
+
+		verificationFailuresInserter: vfi,
+
+		blocksInserter:       bi,
+		listsInserter:        lsi,
+		listItemsInserter:    lii,
+		listBlocksInserter:   lbi,
+		starterPacksInserter: spi,
+		profilesInserter:     pri,
+		postFacetsInserter:   pfi,
+		postMediaInserter:    pmi,
+		threadgatesInserter:  tgi,
+		postgatesInserter:    pgi,
+
+		feedGeneratorsInserter:  fgi,
+		labelerServicesInserter: lsvi,
 	}
 
 	p.inserters = is
 
-	plci, err := clickhouse_inserter.New(ctx, &clickhouse_inserter.Args{
-		PrometheusCounterPrefix: "photocopy_plc_entries",
-		Histogram:               insertionsHist,
-		BatchSize:               100,
-		Logger:                  args.Logger,
-		Conn:                    conn,
-		Query: `INSERT INTO plc (
+	// unlike the firehose tables, plc entries aren't rate limited
+	plcArgs := baseInserterArgs
+	plcArgs.RateLimit = 0
+	plcArgs.PrometheusCounterPrefix = "photocopy_plc_entries"
+	plcArgs.BatchSize = 100
+	plcArgs.Query = `INSERT INTO plc (
 			did, cid, nullified, created_at, plc_op_sig, plc_op_prev, plc_op_type,
 			plc_op_services, plc_op_also_known_as, plc_op_rotation_keys,
 			 plc_tomb_sig, plc_tomb_prev, plc_tomb_type,
 			legacy_op_sig, legacy_op_prev, legacy_op_type, legacy_op_handle,
 			legacy_op_service, legacy_op_signing_key, legacy_op_recovery_key
-		)`,
-	})
+		)`
+
+	plci, err := clickhouse_inserter.New(ctx, &plcArgs)
 	if err != nil {
 		return nil, err
 	}
@@ -232,13 +440,23 @@ func (p *Photocopy) Run(baseCtx context.Context, withBackfill bool) error {
 		}
 	}()
 
+	if err := p.startWorkers(ctx); err != nil {
+		cancel()
+		return fmt.Errorf("failed to start workers: %w", err)
+	}
+
 	go func(ctx context.Context, cancel context.CancelFunc) {
-		p.logger.Info("starting relay", "relayHost", p.relayHost)
+		for _, r := range p.relays {
+			p.logger.Info("starting relay", "relayHost", r.host, "cursorFile", r.cursorFile, "mode", p.relayMode)
+		}
 		if err := p.startConsumer(ctx, cancel); err != nil {
 			panic(fmt.Errorf("failed to start consumer: %w", err))
 		}
 	}(ctx, cancel)
 
+	p.runCascader(ctx)
+	p.runPurger(ctx)
+
 	go func(ctx context.Context) {
 		if err := p.plcScraper.Run(ctx); err != nil {
 			panic(fmt.Errorf("failed to start plc scraper: %w", err))
@@ -255,89 +473,88 @@ func (p *Photocopy) Run(baseCtx context.Context, withBackfill bool) error {
 
 	<-ctx.Done()
 
-	if p.inserters != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-
-		p.logger.Info("stopping inserters")
-
-		if p.inserters.followsInserter != nil {
-			p.wg.Add(1)
-			go func() {
-				defer p.wg.Done()
-				if err := p.inserters.followsInserter.Close(ctx); err != nil {
-					p.logger.Error("failed to close follows inserter", "error", err)
-					return
-				}
-				p.logger.Info("follows inserter closed")
-			}()
-		}
+	// anything still running writes to the inserters and finishes events off in the cursor, so let it
+	// stop before the inserters close and only then save the cursor for the last time
+	p.logger.Info("waiting for workers to stop")
+	p.pool.wait()
+	p.background.Wait()
+	for _, r := range p.relays {
+		p.saveCursor(r)
+	}
 
-		if p.inserters.interactionsInserter != nil {
-			p.wg.Add(1)
-			go func() {
-				defer p.wg.Done()
-				if err := p.inserters.interactionsInserter.Close(ctx); err != nil {
-					p.logger.Error("failed to close interactions inserter", "error", err)
-					return
-				}
-				p.logger.Info("interactions inserter closed")
-			}()
-		}
+	p.closeInserters()
+	p.closeCascader()
+	p.closePurger()
 
-		if p.inserters.postsInserter != nil {
-			p.wg.Add(1)
-			go func() {
-				defer p.wg.Done()
-				if err := p.inserters.postsInserter.Close(ctx); err != nil {
-					p.logger.Error("failed to close posts inserter", "error", err)
-					return
-				}
-				p.logger.Info("posts inserter closed")
-			}()
-		}
+	return nil
+}
 
-		if p.inserters.recordsInserter != nil {
-			p.wg.Add(1)
-			go func() {
-				defer p.wg.Done()
-				if err := p.inserters.recordsInserter.Close(ctx); err != nil {
-					p.logger.Error("failed to close records inserter", "error", err)
-					return
-				}
-				p.logger.Info("records inserter closed")
-			}()
-		}
+func OpenClickhouse(addr, database, user, pass string) (driver.Conn, error) {
+	return clickhouse.Open(&clickhouse.Options{
+		Addr: []string{addr},
+		Auth: clickhouse.Auth{
+			Database: database,
+			Username: user,
+			Password: pass,
+		},
+	})
+}
 
-		if p.inserters.deletesInserter != nil {
-			p.wg.Add(1)
-			go func() {
-				defer p.wg.Done()
-				if err := p.inserters.deletesInserter.Close(ctx); err != nil {
-					p.logger.Error("failed to close deletes inserter", "error", err)
-					return
-				}
-				p.logger.Info("deletes inserter closed")
-			}()
-		}
+func (p *Photocopy) closeInserters() {
+	if p.inserters == nil {
+		return
+	}
 
-		if p.inserters.plcInserter != nil {
-			p.wg.Add(1)
-			go func() {
-				defer p.wg.Done()
-				if err := p.inserters.plcInserter.Close(ctx); err != nil {
-					p.logger.Error("failed to close plc inserter", "error", err)
-					return
-				}
-				p.logger.Info("plc inserter closed")
-			}()
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 
-		p.wg.Wait()
+	p.logger.Info("stopping inserters")
 
-		cancel()
+	for name, inserter := range p.inserters.named() {
+		if inserter == nil {
+			continue
+		}
 
-		p.logger.Info("inserters closed")
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			if err := inserter.Close(ctx); err != nil {
+				p.logger.Error("failed to close inserter", "inserter", name, "error", err)
+				return
+			}
+			p.logger.Info("inserter closed", "inserter", name)
+		}()
 	}
 
-	return nil
+	p.wg.Wait()
+
+	cancel()
+
+	p.logger.Info("inserters closed")
+}
+
+func (is *Inserters) named() map[string]*clickhouse_inserter.Inserter {
+	return map[string]*clickhouse_inserter.Inserter{
+		"follows":               is.followsInserter,
+		"interactions":          is.interactionsInserter,
+		"posts":                 is.postsInserter,
+		"plc":                   is.plcInserter,
+		"records":               is.recordsInserter,
+		"deletes":               is.deletesInserter,
+		"labels":                is.labelsInserter,
+		"identities":            is.identitiesInserter,
+		"accounts":              is.accountsInserter,
+		"verification_failures": is.verificationFailuresInserter,
+		"blocks":                is.blocksInserter,
+		"lists":                 is.listsInserter,
+		"list_items":            is.listItemsInserter,
+		"list_blocks":           is.listBlocksInserter,
+		"starter_packs":         is.starterPacksInserter,
+		"profiles":              is.profilesInserter,
+		"post_facets":           is.postFacetsInserter,
+		"post_media":            is.postMediaInserter,
+		"threadgates":           is.threadgatesInserter,
+		"postgates":             is.postgatesInserter,
+		"feed_generators":       is.feedGeneratorsInserter,
+		"labeler_services":      is.labelerServicesInserter,
+	}
 }
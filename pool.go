@@ -0,0 +1,83 @@
+package photocopy
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// workerPool runs work on a fixed number of workers. Work is sharded by key so that everything for a
+// given did is processed in the order it was submitted. Submitting blocks once a shard's queue is full,
+// which in turn stops us from reading off the websocket until the workers catch up.
+type workerPool struct {
+	shards []chan func()
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(workers, queueSize int) *workerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	wp := &workerPool{
+		shards: make([]chan func(), workers),
+	}
+	for i := range wp.shards {
+		wp.shards[i] = make(chan func(), queueSize)
+	}
+
+	return wp
+}
+
+func (wp *workerPool) run(ctx context.Context) {
+	for _, shard := range wp.shards {
+		wp.wg.Add(1)
+		go func() {
+			defer wp.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case fn := <-shard:
+					workerQueueDepth.Dec()
+					workersBusy.Inc()
+					fn()
+					workersBusy.Dec()
+				}
+			}
+		}()
+	}
+}
+
+// wait blocks until every worker has returned after the context passed to run is cancelled. Work still
+// queued at that point is never run, so the seqs it holds stay in flight and the cursor doesn't pass them.
+func (wp *workerPool) wait() {
+	wp.wg.Wait()
+}
+
+func (wp *workerPool) submit(ctx context.Context, key string, fn func()) error {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := wp.shards[h.Sum32()%uint32(len(wp.shards))]
+
+	select {
+	case shard <- fn:
+		workerQueueDepth.Inc()
+		return nil
+	default:
+	}
+
+	start := time.Now()
+	defer func() {
+		workerSubmitBlocked.Add(time.Since(start).Seconds())
+	}()
+
+	select {
+	case shard <- fn:
+		workerQueueDepth.Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
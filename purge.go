@@ -0,0 +1,261 @@
+package photocopy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+const (
+	purgeInterval = 30 * time.Second
+	purgeChunk    = 500
+)
+
+type purgeTarget struct {
+	table   string
+	column  string
+	subject bool
+}
+
+var purgeTargets = []purgeTarget{
+	{"record", "did", false},
+	{"post", "did", false},
+	{"post_facet", "did", false},
+	{"post_media", "did", false},
+	{"post_label", "did", false},
+	{"follow", "did", false},
+	{"interaction", "did", false},
+	{"block", "did", false},
+	{"list", "did", false},
+	{"list_item", "did", false},
+	{"list_block", "did", false},
+	{"starter_pack", "did", false},
+	{"profile", "did", false},
+	{"threadgate", "did", false},
+	{"postgate", "did", false},
+	{"feed_generator", "did", false},
+	{"labeler_service", "did", false},
+	{"delete", "did", false},
+	{"identity", "did", false},
+	{"account", "did", false},
+	{"verification_failure", "did", false},
+	{"plc", "did", false},
+
+	{"post", "parent_did", true},
+	{"post", "root_did", true},
+	{"post", "quote_did", true},
+	{"post_facet", "mention_did", true},
+	{"follow", "subject", true},
+	{"interaction", "subject_did", true},
+	{"interaction", "via_did", true},
+	{"block", "subject", true},
+	{"list_item", "subject", true},
+	{"list_block", "subject_did", true},
+}
+
+// automatic purges of deleted accounts keep the account's delete, identity and account rows, so there's
+// still a record of the account and of its deletion
+var autoPurgeTargets = slices.DeleteFunc(slices.Clone(purgeTargets), func(t purgeTarget) bool {
+	return t.table == "delete" || t.table == "identity" || t.table == "account"
+})
+
+type PurgeOptions struct {
+	// also remove other accounts' rows that point at the did, like replies, follows and likes
+	Subjects bool
+	// only count the rows that would be removed
+	DryRun bool
+}
+
+type PurgeCount struct {
+	Table  string
+	Column string
+	Rows   uint64
+}
+
+// Purge removes every row stored for did with lightweight deletes and returns how many rows matched
+// in each table.
+func Purge(ctx context.Context, conn driver.Conn, did string, opts PurgeOptions) ([]PurgeCount, error) {
+	if did == "" {
+		return nil, fmt.Errorf("did is required")
+	}
+
+	return purgeDids(ctx, conn, purgeTargets, []string{did}, opts)
+}
+
+// purgeDids is Purge for a batch of dids and a given set of targets, so that purging many accounts costs
+// one count and one delete per table rather than one per account.
+func purgeDids(ctx context.Context, conn driver.Conn, targets []purgeTarget, dids []string, opts PurgeOptions) ([]PurgeCount, error) {
+	var counts []PurgeCount
+	for _, t := range targets {
+		if t.subject && !opts.Subjects {
+			continue
+		}
+
+		var rows uint64
+		if err := conn.QueryRow(ctx, fmt.Sprintf("SELECT count() FROM `%s` WHERE has(?, %s)", t.table, t.column), dids).Scan(&rows); err != nil {
+			return counts, fmt.Errorf("failed to count rows in %s: %w", t.table, err)
+		}
+
+		if rows > 0 && !opts.DryRun {
+			if err := conn.Exec(ctx, fmt.Sprintf("DELETE FROM `%s` WHERE has(?, %s)", t.table, t.column), dids); err != nil {
+				return counts, fmt.Errorf("failed to purge rows from %s: %w", t.table, err)
+			}
+			purgedRows.WithLabelValues(t.table).Add(float64(rows))
+		}
+
+		counts = append(counts, PurgeCount{Table: t.table, Column: t.column, Rows: rows})
+	}
+
+	return counts, nil
+}
+
+type pendingPurge struct {
+	Did string    `json:"did"`
+	At  time.Time `json:"at"`
+}
+
+// purger batches up automatic purges of deleted accounts. the queue is written to disk whenever it
+// changes, so purges that were waiting out the delay when we stopped still happen after a restart.
+type purger struct {
+	mu     sync.Mutex
+	queued []pendingPurge
+	// the same wait as the cascader, so rows batched when the account was deleted land before the purge
+	delay time.Duration
+	file  string
+}
+
+func newPurger(file string, flushInterval time.Duration, maxRetries int, retryBackoff time.Duration) (*purger, error) {
+	delay, err := insertSettleDelay(flushInterval, maxRetries, retryBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("purging deleted accounts: %w", err)
+	}
+
+	pr := &purger{
+		delay: delay,
+		file:  file,
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		if err := json.Unmarshal(b, &pr.queued); err != nil {
+			return nil, fmt.Errorf("invalid pending purges in %s: %w", file, err)
+		}
+	}
+	purgesQueued.Set(float64(len(pr.queued)))
+
+	return pr, nil
+}
+
+// saveLocked writes the queue to disk. the caller must hold mu.
+func (pr *purger) saveLocked() error {
+	b, err := json.Marshal(pr.queued)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(pr.file, b, 0644)
+}
+
+func (p *Photocopy) enqueuePurge(did string) {
+	if p.purger == nil {
+		return
+	}
+
+	p.purger.mu.Lock()
+	defer p.purger.mu.Unlock()
+
+	// an account can be reported deleted more than once, by more than one relay
+	if slices.ContainsFunc(p.purger.queued, func(pp pendingPurge) bool { return pp.Did == did }) {
+		return
+	}
+
+	p.purger.queued = append(p.purger.queued, pendingPurge{Did: did, At: time.Now()})
+	purgesQueued.Set(float64(len(p.purger.queued)))
+
+	if err := p.purger.saveLocked(); err != nil {
+		p.logger.Error("failed to save pending purges", "file", p.purger.file, "error", err)
+	}
+}
+
+func (p *Photocopy) runPurger(ctx context.Context) {
+	if p.purger == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.flushPurges(ctx, false)
+			}
+		}
+	}()
+}
+
+// closePurger runs whatever purges are still queued, after the inserters are closed so the rows being
+// purged have actually been written. anything that fails stays on disk for the next start.
+func (p *Photocopy) closePurger() {
+	if p.purger == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p.flushPurges(ctx, true)
+}
+
+func (p *Photocopy) flushPurges(ctx context.Context, force bool) {
+	cutoff := time.Now().Add(-p.purger.delay)
+
+	p.purger.mu.Lock()
+	var ready []string
+	for _, pp := range p.purger.queued {
+		if force || pp.At.Before(cutoff) {
+			ready = append(ready, pp.Did)
+		}
+	}
+	p.purger.mu.Unlock()
+
+	for chunk := range slices.Chunk(ready, purgeChunk) {
+		if err := ctx.Err(); err != nil {
+			return
+		}
+
+		counts, err := purgeDids(ctx, p.conn, autoPurgeTargets, chunk, PurgeOptions{})
+		if err != nil {
+			// deletes are idempotent, so the whole chunk is simply retried next time
+			p.logger.Error("failed to purge deleted accounts", "count", len(chunk), "error", err)
+			continue
+		}
+
+		var total uint64
+		for _, c := range counts {
+			total += c.Rows
+		}
+		p.logger.Info("purged deleted accounts", "count", len(chunk), "rows", total)
+
+		p.purger.mu.Lock()
+		p.purger.queued = slices.DeleteFunc(p.purger.queued, func(pp pendingPurge) bool {
+			return slices.Contains(chunk, pp.Did)
+		})
+		purgesQueued.Set(float64(len(p.purger.queued)))
+		if err := p.purger.saveLocked(); err != nil {
+			p.logger.Error("failed to save pending purges", "file", p.purger.file, "error", err)
+		}
+		p.purger.mu.Unlock()
+	}
+}
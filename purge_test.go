@@ -0,0 +1,188 @@
+package photocopy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+)
+
+type countRow struct {
+	driver.Row
+	rows uint64
+}
+
+func (r countRow) Scan(dest ...any) error {
+	*dest[0].(*uint64) = r.rows
+	return nil
+}
+
+// purgeConn reports one matching row per table and records the dids each delete was issued for, and
+// the queries that issued them
+type purgeConn struct {
+	driver.Conn
+	deletes [][]string
+	queries []string
+	failing bool
+}
+
+func (c *purgeConn) QueryRow(ctx context.Context, query string, args ...any) driver.Row {
+	return countRow{rows: 1}
+}
+
+func (c *purgeConn) Exec(ctx context.Context, query string, args ...any) error {
+	if c.failing {
+		return errors.New("clickhouse is down")
+	}
+	if !strings.Contains(query, "WHERE has(?, did)") {
+		return errors.New("unexpected purge query: " + query)
+	}
+	c.deletes = append(c.deletes, args[0].([]string))
+	c.queries = append(c.queries, query)
+	return nil
+}
+
+func TestPurgerQueue(t *testing.T) {
+	tests := []struct {
+		name       string
+		enqueue    []string
+		failing    bool
+		wantBatch  []string
+		wantQueued []string
+	}{
+		{
+			name:       "deleted accounts are purged in one batch",
+			enqueue:    []string{"did:plc:a", "did:plc:b"},
+			wantBatch:  []string{"did:plc:a", "did:plc:b"},
+			wantQueued: nil,
+		},
+		{
+			name:       "repeated deletions are only purged once",
+			enqueue:    []string{"did:plc:a", "did:plc:a", "did:plc:b", "did:plc:a"},
+			wantBatch:  []string{"did:plc:a", "did:plc:b"},
+			wantQueued: nil,
+		},
+		{
+			name:       "failed purges stay queued on disk",
+			enqueue:    []string{"did:plc:a"},
+			failing:    true,
+			wantQueued: []string{"did:plc:a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "cursor.purges")
+
+			pr, err := newPurger(file, 10*time.Second, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			conn := &purgeConn{failing: tt.failing}
+			p := &Photocopy{
+				logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+				conn:   conn,
+				purger: pr,
+			}
+
+			for _, did := range tt.enqueue {
+				p.enqueuePurge(did)
+			}
+
+			// nothing is purged before the delay, and the queue survives a restart
+			p.flushPurges(context.Background(), false)
+			if len(conn.deletes) != 0 {
+				t.Fatalf("purged before the delay: %v", conn.deletes)
+			}
+
+			restarted, err := newPurger(file, 10*time.Second, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var pending []string
+			for _, did := range tt.enqueue {
+				if !slices.Contains(pending, did) {
+					pending = append(pending, did)
+				}
+			}
+			if got := queuedDids(restarted); !slices.Equal(got, pending) {
+				t.Fatalf("reloaded queue = %v, want %v", got, pending)
+			}
+			p.purger = restarted
+
+			p.flushPurges(context.Background(), true)
+
+			if tt.wantBatch != nil {
+				// one delete per table that's keyed by the account's own did, each covering the whole batch
+				if len(conn.deletes) == 0 {
+					t.Fatal("nothing was purged")
+				}
+				for _, dids := range conn.deletes {
+					if !slices.Equal(dids, tt.wantBatch) {
+						t.Fatalf("purged %v, want %v", dids, tt.wantBatch)
+					}
+				}
+				// the record of the account and its deletion is kept
+				for _, q := range conn.queries {
+					for _, kept := range []string{"`delete`", "`identity`", "`account`"} {
+						if strings.Contains(q, kept) {
+							t.Errorf("automatic purge ran %q", q)
+						}
+					}
+				}
+			}
+
+			if got := queuedDids(p.purger); !slices.Equal(got, tt.wantQueued) {
+				t.Errorf("queued = %v, want %v", got, tt.wantQueued)
+			}
+
+			reloaded, err := newPurger(file, 10*time.Second, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := queuedDids(reloaded); !slices.Equal(got, tt.wantQueued) {
+				t.Errorf("queued on disk = %v, want %v", got, tt.wantQueued)
+			}
+		})
+	}
+}
+
+func TestNewPurgerRequiresFlushInterval(t *testing.T) {
+	if _, err := newPurger(filepath.Join(t.TempDir(), "cursor.purges"), 0, 5, time.Second); err == nil {
+		t.Fatal("expected an error without a flush interval")
+	}
+}
+
+func queuedDids(pr *purger) []string {
+	var dids []string
+	for _, pp := range pr.queued {
+		dids = append(dids, pp.Did)
+	}
+	return dids
+}
+
+func TestPurgeRemovesEverything(t *testing.T) {
+	conn := &purgeConn{}
+	counts, err := Purge(context.Background(), conn, "did:plc:a", PurgeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tables []string
+	for _, c := range counts {
+		tables = append(tables, c.Table)
+	}
+	for _, want := range []string{"delete", "identity", "account"} {
+		if !slices.Contains(tables, want) {
+			t.Errorf("manual purge skipped %s", want)
+		}
+	}
+}
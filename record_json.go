@@ -0,0 +1,32 @@
+package photocopy
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/bluesky-social/indigo/atproto/data"
+)
+
+const (
+	RecordJSONOff     = "off"
+	RecordJSONAlso    = "also"
+	RecordJSONInstead = "instead"
+)
+
+// recordJSON renders a dag-cbor record as atproto flavored dag-json. this goes through the generic
+// data model rather than lexgen types, so records from lexicons we don't know about work too
+func recordJSON(raw []byte) (string, error) {
+	obj, err := data.UnmarshalCBOR(raw)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return "", err
+	}
+
+	return string(bytes.TrimRight(buf.Bytes(), "\n")), nil
+}
@@ -0,0 +1,220 @@
+package photocopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	RelayModeParallel = "parallel"
+	RelayModeFailover = "failover"
+)
+
+// in failover mode, this many failed connection attempts in a row moves us on to the next relay
+const failoverAttempts = 3
+
+var errRelayStalled = errors.New("relay stalled")
+
+type relay struct {
+	host       string
+	cursorFile string
+	cursor     *cursorTracker
+	lastEvent  atomic.Int64
+
+	saveMu   sync.Mutex
+	savedSeq int64
+}
+
+// newRelays sets up state for each configured relay. The first relay keeps the configured cursor file
+// as-is so that single relay setups are unaffected, and the rest get the hostname appended.
+func newRelays(hosts []string, cursorFile string) []*relay {
+	relays := make([]*relay, 0, len(hosts))
+	for i, host := range hosts {
+		r := &relay{
+			host:       host,
+			cursorFile: cursorFile,
+		}
+		if i > 0 {
+			name := host
+			if u, err := url.Parse(host); err == nil && u.Host != "" {
+				name = u.Host
+			}
+			r.cursorFile = cursorFile + "." + strings.NewReplacer("/", "_", ":", "_").Replace(name)
+		}
+		relays = append(relays, r)
+	}
+	return relays
+}
+
+func (r *relay) touch() {
+	r.lastEvent.Store(time.Now().UnixNano())
+}
+
+func (r *relay) sinceLastEvent() time.Duration {
+	return time.Since(time.Unix(0, r.lastEvent.Load()))
+}
+
+// observe records that an event came in from this relay, with evtTime being the time the event claims
+// to have been emitted.
+func (r *relay) observe(seq int64, evtTime string) {
+	r.touch()
+	relayEvents.WithLabelValues(r.host).Inc()
+	relaySeq.WithLabelValues(r.host).Set(float64(seq))
+
+	if t, err := time.Parse(time.RFC3339Nano, evtTime); err == nil {
+		relayLag.WithLabelValues(r.host).Set(time.Since(t).Seconds())
+	}
+}
+
+func (r *relay) loadCursor() (string, error) {
+	b, err := os.ReadFile(r.cursorFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (r *relay) initCursor() error {
+	prevCursor, err := r.loadCursor()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var startSeq int64
+	if prevCursor != "" {
+		startSeq, err = strconv.ParseInt(prevCursor, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cursor in %s: %w", r.cursorFile, err)
+		}
+	}
+	r.cursor = newCursorTracker(startSeq)
+
+	return nil
+}
+
+// runFailover consumes from one relay at a time, moving on to the next one whenever the current relay
+// stops being usable.
+func (p *Photocopy) runFailover(ctx context.Context) error {
+	var prev *relay
+	for i := 0; ; i = (i + 1) % len(p.relays) {
+		r := p.relays[i]
+		if prev != nil {
+			p.checkStandbyCursor(prev, r)
+		}
+
+		relayActive.WithLabelValues(r.host).Set(1)
+		err := p.runRelay(ctx, r, true)
+		relayActive.WithLabelValues(r.host).Set(0)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		next := p.relays[(i+1)%len(p.relays)]
+		p.logger.Warn("failing over to next relay", "from", r.host, "to", next.host, "error", err)
+		relayFailovers.WithLabelValues(r.host, next.host).Inc()
+		prev = r
+	}
+}
+
+// checkStandbyCursor warns when failing over onto a relay that has never been consumed from. Seqs are
+// assigned by each relay independently, so there is no way to translate the previous relay's watermark
+// into a cursor for this one, and the relay will start at its live tip. Everything emitted between the
+// last event from prev and that connection is lost and has to be backfilled.
+func (p *Photocopy) checkStandbyCursor(prev, r *relay) {
+	if _, err := r.loadCursor(); !os.IsNotExist(err) {
+		return
+	}
+
+	relayCursorlessFailovers.WithLabelValues(r.host).Inc()
+
+	// if the previous relay never connected there's nothing to measure the gap from
+	var gap time.Duration
+	if prev.lastEvent.Load() != 0 {
+		gap = prev.sinceLastEvent()
+		relayFailoverGap.WithLabelValues(r.host).Add(gap.Seconds())
+	}
+	p.logger.Warn("failing over to a relay with no saved cursor, events since the last one from the previous relay will be missed",
+		"relay", r.host, "previous", prev.host, "previous_cursor", prev.cursor.watermark(), "gap", gap)
+}
+
+func (p *Photocopy) runParallel(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, r := range p.relays {
+		relayActive.WithLabelValues(r.host).Set(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.runRelay(ctx, r, false)
+		}()
+	}
+	wg.Wait()
+
+	return nil
+}
+
+// dedupCache remembers recently seen keys so that the same op arriving from more than one relay is only
+// ingested once. It keeps two generations and drops the older one whenever the newer fills up, so memory
+// stays bounded without tracking per key expiry.
+type dedupCache struct {
+	mu      sync.Mutex
+	cur     map[string]struct{}
+	prev    map[string]struct{}
+	maxSize int
+}
+
+func newDedupCache(maxSize int) *dedupCache {
+	return &dedupCache{
+		cur:     make(map[string]struct{}),
+		prev:    make(map[string]struct{}),
+		maxSize: maxSize,
+	}
+}
+
+// checkAndMark reports whether key has already been seen, and marks it as seen if not, under a single
+// lock so two relays can't both claim the same key. A caller that fails to hand the event off should
+// unmark the key so a copy from another relay can still be ingested.
+func (d *dedupCache) checkAndMark(key string) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.cur[key]; ok {
+		return true
+	}
+	if _, ok := d.prev[key]; ok {
+		return true
+	}
+
+	d.cur[key] = struct{}{}
+	if len(d.cur) >= d.maxSize {
+		d.prev = d.cur
+		d.cur = make(map[string]struct{}, d.maxSize)
+	}
+
+	return false
+}
+
+// unmark releases a key claimed by checkAndMark.
+func (d *dedupCache) unmark(key string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.cur, key)
+	delete(d.prev, key)
+}
@@ -0,0 +1,109 @@
+package photocopy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDedupCache(t *testing.T) {
+	type step struct {
+		mark   []string
+		unmark []string
+		seen   map[string]bool
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int
+		steps   []step
+	}{
+		{
+			name:    "the first check claims the key",
+			maxSize: 10,
+			steps: []step{
+				{seen: map[string]bool{"a": false}},
+				{seen: map[string]bool{"a": true}},
+			},
+		},
+		{
+			name:    "a full generation is kept as the previous one",
+			maxSize: 2,
+			steps: []step{
+				{mark: []string{"a", "b"}},
+				{mark: []string{"c"}, seen: map[string]bool{"a": true, "b": true, "c": true}},
+			},
+		},
+		{
+			name:    "the oldest generation is dropped when the newer fills",
+			maxSize: 2,
+			steps: []step{
+				{mark: []string{"a", "b"}},
+				{mark: []string{"c", "d"}, seen: map[string]bool{"a": false}},
+			},
+		},
+		{
+			name:    "an unmarked key can be claimed again",
+			maxSize: 10,
+			steps: []step{
+				{mark: []string{"a"}, unmark: []string{"a"}, seen: map[string]bool{"a": false}},
+				{seen: map[string]bool{"a": true}},
+			},
+		},
+		{
+			name:    "unmark reaches the previous generation",
+			maxSize: 2,
+			steps: []step{
+				{mark: []string{"a", "b"}, unmark: []string{"a"}, seen: map[string]bool{"a": false, "b": true}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDedupCache(tt.maxSize)
+			for i, s := range tt.steps {
+				for _, key := range s.mark {
+					d.checkAndMark(key)
+				}
+				for _, key := range s.unmark {
+					d.unmark(key)
+				}
+				for key, want := range s.seen {
+					if got := d.checkAndMark(key); got != want {
+						t.Errorf("step %d: checkAndMark(%q) = %v, want %v", i, key, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDedupCacheClaimsOnce(t *testing.T) {
+	d := newDedupCache(1000)
+
+	var claimed atomic.Int64
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !d.checkAndMark("did:plc:a|3kabc|app.bsky.feed.post/3kabc") {
+				claimed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := claimed.Load(); n != 1 {
+		t.Fatalf("key was claimed %d times, want once", n)
+	}
+}
+
+func TestNilDedupCache(t *testing.T) {
+	var d *dedupCache
+	d.unmark("a")
+	if d.checkAndMark("a") {
+		t.Fatal("nil cache reported a key as seen")
+	}
+}
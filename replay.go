@@ -0,0 +1,91 @@
+package photocopy
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var errReplayDone = errors.New("replay reached to-seq")
+
+// Replay feeds captured segments from dir through the same callbacks used for the live relay, then
+// flushes the inserters. fromSeq and toSeq are inclusive, and a toSeq of zero replays to the end. The
+// relay cursor file is never touched.
+func (p *Photocopy) Replay(ctx context.Context, dir string, fromSeq, toSeq int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r := &relay{
+		host:   "replay",
+		cursor: newCursorTracker(fromSeq),
+	}
+	p.pool.run(ctx)
+	p.runTooBigRecoverer(ctx)
+	if p.nervanaClient != nil {
+		p.runNervanaWorkers(ctx)
+	}
+	p.runCascader(ctx)
+	p.runPurger(ctx)
+
+	rsc := p.streamCallbacks(ctx, r)
+
+	segs, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	replayed := 0
+	for _, seg := range segs {
+		if seg.lastSeq < fromSeq || (toSeq != 0 && seg.firstSeq > toSeq) {
+			continue
+		}
+
+		p.logger.Info("replaying segment", "path", seg.path, "first_seq", seg.firstSeq, "last_seq", seg.lastSeq)
+
+		err := readSegment(seg.path, func(seq int64, frame []byte) error {
+			if seq < fromSeq {
+				return nil
+			}
+			if toSeq != 0 && seq > toSeq {
+				return errReplayDone
+			}
+
+			evt, _, err := decodeFrame(frame)
+			if err != nil {
+				p.logger.Error("failed to decode captured frame", "seq", seq, "error", err)
+				return nil
+			}
+
+			replayed++
+			return rsc.EventHandler(ctx, evt)
+		})
+		if errors.Is(err, errReplayDone) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// too big recoveries and nervana lookups carry on after the event that queued them is done, and
+	// they still need the inserters
+	for r.cursor.pending() > 0 || p.tooBigInflight.Load() > 0 || p.nervanaInflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	p.logger.Info("replay finished", "events", replayed, "seq", r.cursor.watermark())
+
+	cancel()
+	p.pool.wait()
+	p.background.Wait()
+
+	p.closeInserters()
+	p.closeCascader()
+	p.closePurger()
+
+	return nil
+}
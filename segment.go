@@ -0,0 +1,349 @@
+package photocopy
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+// Captured firehose frames are stored in gzipped segment files named after the first and last seq they
+// contain, so a seq range can be found from a directory listing alone. Each frame is written as a uvarint
+// seq, a uvarint length, and then the raw websocket message exactly as the relay sent it.
+const (
+	segmentExt         = ".seg.gz"
+	partialSegmentName = "current.partial"
+)
+
+type segmentInfo struct {
+	path     string
+	firstSeq int64
+	lastSeq  int64
+}
+
+type segmentWriter struct {
+	dir       string
+	maxFrames int
+
+	f        *os.File
+	gz       *gzip.Writer
+	bw       *bufio.Writer
+	frames   int
+	firstSeq int64
+	lastSeq  int64
+}
+
+func newSegmentWriter(dir string, maxFrames int) (*segmentWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &segmentWriter{
+		dir:       dir,
+		maxFrames: maxFrames,
+	}
+
+	// a partial segment left behind by a crash is still readable up to the point it was cut off
+	partial := filepath.Join(dir, partialSegmentName)
+	if _, err := os.Stat(partial); err == nil {
+		if err := finalizePartialSegment(dir, partial); err != nil {
+			return nil, fmt.Errorf("failed to recover partial segment: %w", err)
+		}
+	}
+
+	return w, nil
+}
+
+func (w *segmentWriter) write(seq int64, frame []byte) error {
+	if w.f == nil {
+		f, err := os.Create(filepath.Join(w.dir, partialSegmentName))
+		if err != nil {
+			return err
+		}
+		w.f = f
+		w.gz = gzip.NewWriter(f)
+		w.bw = bufio.NewWriter(w.gz)
+		w.frames = 0
+		w.firstSeq = seq
+	}
+
+	var hdr [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(hdr[:], uint64(seq))
+	n += binary.PutUvarint(hdr[n:], uint64(len(frame)))
+	if _, err := w.bw.Write(hdr[:n]); err != nil {
+		return err
+	}
+	if _, err := w.bw.Write(frame); err != nil {
+		return err
+	}
+
+	w.frames++
+	w.lastSeq = seq
+
+	if w.frames >= w.maxFrames {
+		return w.rotate()
+	}
+
+	return nil
+}
+
+func (w *segmentWriter) rotate() error {
+	if w.f == nil {
+		return nil
+	}
+
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+
+	name := segmentName(w.dir, w.firstSeq, w.lastSeq)
+	if err := os.Rename(w.f.Name(), name); err != nil {
+		return err
+	}
+
+	w.f = nil
+	w.gz = nil
+	w.bw = nil
+
+	return nil
+}
+
+func (w *segmentWriter) close() error {
+	return w.rotate()
+}
+
+func segmentName(dir string, firstSeq, lastSeq int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d-%020d%s", firstSeq, lastSeq, segmentExt))
+}
+
+func finalizePartialSegment(dir, partial string) error {
+	var first, last int64 = -1, -1
+	if err := readSegment(partial, func(seq int64, _ []byte) error {
+		if first == -1 {
+			first = seq
+		}
+		last = seq
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if first == -1 {
+		return os.Remove(partial)
+	}
+
+	return os.Rename(partial, segmentName(dir, first, last))
+}
+
+// listSegments returns the finished segments in dir ordered by seq.
+func listSegments(dir string) ([]segmentInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segs []segmentInfo
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		var seg segmentInfo
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, segmentExt), "%d-%d", &seg.firstSeq, &seg.lastSeq); err != nil {
+			continue
+		}
+		seg.path = filepath.Join(dir, name)
+		segs = append(segs, seg)
+	}
+
+	slices.SortFunc(segs, func(a, b segmentInfo) int {
+		return cmp.Compare(a.firstSeq, b.firstSeq)
+	})
+
+	return segs, nil
+}
+
+// readSegment calls fn for every frame in the segment. A truncated segment is read up to the last
+// complete frame rather than treated as an error.
+func readSegment(path string, fn func(seq int64, frame []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	defer gz.Close()
+
+	br := bufio.NewReader(gz)
+	for {
+		seq, err := binary.ReadUvarint(br)
+		if err != nil {
+			if isTruncated(err) {
+				return nil
+			}
+			return err
+		}
+
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			if isTruncated(err) {
+				return nil
+			}
+			return err
+		}
+
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(br, frame); err != nil {
+			if isTruncated(err) {
+				return nil
+			}
+			return err
+		}
+
+		if err := fn(int64(seq), frame); err != nil {
+			return err
+		}
+	}
+}
+
+func isTruncated(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// frameSeq reads the seq out of a message frame without decoding the rest of it, so capture can keep
+// frames of types it doesn't know about or can't decode. Frames without a seq return zero.
+func frameSeq(frame []byte) (int64, error) {
+	r := bytes.NewReader(frame)
+
+	var header events.EventHeader
+	if err := header.UnmarshalCBOR(r); err != nil {
+		return 0, fmt.Errorf("reading header: %w", err)
+	}
+
+	if header.Op != events.EvtKindMessage {
+		return 0, nil
+	}
+
+	cr := cbg.NewCborReader(r)
+	maj, n, err := cr.ReadHeader()
+	if err != nil {
+		return 0, fmt.Errorf("reading body: %w", err)
+	}
+	if maj != cbg.MajMap {
+		return 0, fmt.Errorf("message body is not a map")
+	}
+
+	// keys are in dag-cbor order, so seq comes before the large fields like blocks
+	for range n {
+		key, err := cbg.ReadStringWithMax(cr, 1024)
+		if err != nil {
+			return 0, fmt.Errorf("reading body key: %w", err)
+		}
+
+		if key != "seq" {
+			var skip cbg.Deferred
+			if err := skip.UnmarshalCBOR(cr); err != nil {
+				return 0, fmt.Errorf("skipping %s: %w", key, err)
+			}
+			continue
+		}
+
+		maj, v, err := cr.ReadHeader()
+		if err != nil {
+			return 0, fmt.Errorf("reading seq: %w", err)
+		}
+		if maj != cbg.MajUnsignedInt {
+			return 0, fmt.Errorf("seq is not an unsigned integer")
+		}
+		return int64(v), nil
+	}
+
+	return 0, nil
+}
+
+// decodeFrame parses a raw subscribeRepos websocket message. The returned seq is zero for frames that
+// don't carry one, like #info and error frames.
+func decodeFrame(frame []byte) (*events.XRPCStreamEvent, int64, error) {
+	r := bytes.NewReader(frame)
+
+	var header events.EventHeader
+	if err := header.UnmarshalCBOR(r); err != nil {
+		return nil, 0, fmt.Errorf("reading header: %w", err)
+	}
+
+	switch header.Op {
+	case events.EvtKindMessage:
+	case events.EvtKindErrorFrame:
+		var errframe events.ErrorFrame
+		if err := errframe.UnmarshalCBOR(r); err != nil {
+			return nil, 0, err
+		}
+		return &events.XRPCStreamEvent{Error: &errframe}, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("unrecognized event stream type: %d", header.Op)
+	}
+
+	switch header.MsgType {
+	case "#commit":
+		var evt atproto.SyncSubscribeRepos_Commit
+		if err := evt.UnmarshalCBOR(r); err != nil {
+			return nil, 0, fmt.Errorf("reading repoCommit event: %w", err)
+		}
+		return &events.XRPCStreamEvent{RepoCommit: &evt}, evt.Seq, nil
+	case "#sync":
+		var evt atproto.SyncSubscribeRepos_Sync
+		if err := evt.UnmarshalCBOR(r); err != nil {
+			return nil, 0, fmt.Errorf("reading repoSync event: %w", err)
+		}
+		return &events.XRPCStreamEvent{RepoSync: &evt}, evt.Seq, nil
+	case "#identity":
+		var evt atproto.SyncSubscribeRepos_Identity
+		if err := evt.UnmarshalCBOR(r); err != nil {
+			return nil, 0, fmt.Errorf("reading repoIdentity event: %w", err)
+		}
+		return &events.XRPCStreamEvent{RepoIdentity: &evt}, evt.Seq, nil
+	case "#account":
+		var evt atproto.SyncSubscribeRepos_Account
+		if err := evt.UnmarshalCBOR(r); err != nil {
+			return nil, 0, fmt.Errorf("reading repoAccount event: %w", err)
+		}
+		return &events.XRPCStreamEvent{RepoAccount: &evt}, evt.Seq, nil
+	case "#info":
+		var evt atproto.SyncSubscribeRepos_Info
+		if err := evt.UnmarshalCBOR(r); err != nil {
+			return nil, 0, fmt.Errorf("reading repoInfo event: %w", err)
+		}
+		return &events.XRPCStreamEvent{RepoInfo: &evt}, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("unrecognized message type %s", header.MsgType)
+	}
+}
@@ -0,0 +1,330 @@
+package photocopy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/events"
+	lexutil "github.com/bluesky-social/indigo/lex/util"
+	"github.com/ipfs/go-cid"
+	cbg "github.com/whyrusleeping/cbor-gen"
+)
+
+type testFrame struct {
+	seq   int64
+	frame []byte
+}
+
+func readAllFrames(t *testing.T, path string) []testFrame {
+	t.Helper()
+
+	var frames []testFrame
+	if err := readSegment(path, func(seq int64, frame []byte) error {
+		frames = append(frames, testFrame{seq: seq, frame: frame})
+		return nil
+	}); err != nil {
+		t.Fatalf("readSegment(%s): %v", path, err)
+	}
+	return frames
+}
+
+func TestSegmentWriteRotateRead(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxFrames int
+		seqs      []int64
+		want      [][2]int64
+	}{
+		{
+			name:      "single partial segment is finished on close",
+			maxFrames: 10,
+			seqs:      []int64{1, 2, 3},
+			want:      [][2]int64{{1, 3}},
+		},
+		{
+			name:      "rotates at max frames",
+			maxFrames: 2,
+			seqs:      []int64{5, 6, 7, 8, 9},
+			want:      [][2]int64{{5, 6}, {7, 8}, {9, 9}},
+		},
+		{
+			name:      "gaps are kept in the segment names",
+			maxFrames: 2,
+			seqs:      []int64{100, 250, 900},
+			want:      [][2]int64{{100, 250}, {900, 900}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			w, err := newSegmentWriter(dir, tt.maxFrames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, seq := range tt.seqs {
+				if err := w.write(seq, []byte{byte(seq)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.close(); err != nil {
+				t.Fatal(err)
+			}
+
+			segs, err := listSegments(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(segs) != len(tt.want) {
+				t.Fatalf("got %d segments, want %d", len(segs), len(tt.want))
+			}
+
+			var got []int64
+			for i, seg := range segs {
+				if seg.firstSeq != tt.want[i][0] || seg.lastSeq != tt.want[i][1] {
+					t.Errorf("segment %d covers %d-%d, want %d-%d", i, seg.firstSeq, seg.lastSeq, tt.want[i][0], tt.want[i][1])
+				}
+				for _, f := range readAllFrames(t, seg.path) {
+					if !bytes.Equal(f.frame, []byte{byte(f.seq)}) {
+						t.Errorf("frame for seq %d = %v", f.seq, f.frame)
+					}
+					got = append(got, f.seq)
+				}
+			}
+
+			if len(got) != len(tt.seqs) {
+				t.Fatalf("read %v, want %v", got, tt.seqs)
+			}
+			for i := range got {
+				if got[i] != tt.seqs[i] {
+					t.Fatalf("read %v, want %v", got, tt.seqs)
+				}
+			}
+		})
+	}
+}
+
+func TestReadTruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+
+	w, err := newSegmentWriter(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := int64(1); seq <= 3; seq++ {
+		if err := w.write(seq, bytes.Repeat([]byte{byte(seq)}, 64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segs, err := listSegments(dir)
+	if err != nil || len(segs) != 1 {
+		t.Fatalf("listSegments: %v, %v", segs, err)
+	}
+
+	// rewrite the segment without the end of its last frame, like a crash mid-write would leave it
+	var raw bytes.Buffer
+	if err := readSegment(segs[0].path, func(seq int64, frame []byte) error {
+		raw.WriteByte(byte(seq))
+		raw.WriteByte(byte(len(frame)))
+		raw.Write(frame)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cut := raw.Bytes()[:raw.Len()-10]
+
+	truncated := filepath.Join(dir, "truncated"+segmentExt)
+	f, err := os.Create(truncated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(cut); err != nil {
+		t.Fatal(err)
+	}
+	// flush without closing so the gzip trailer is missing too
+	if err := gz.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	frames := readAllFrames(t, truncated)
+	if len(frames) != 2 || frames[0].seq != 1 || frames[1].seq != 2 {
+		t.Fatalf("got %v, want seqs 1 and 2", frames)
+	}
+}
+
+func TestRecoverPartialSegment(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []int64
+		want [][2]int64
+	}{
+		{
+			name: "partial with frames is renamed to its seq range",
+			seqs: []int64{40, 41, 42},
+			want: [][2]int64{{40, 42}},
+		},
+		{
+			name: "empty partial is removed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			w, err := newSegmentWriter(dir, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.seqs) == 0 {
+				if err := os.WriteFile(filepath.Join(dir, partialSegmentName), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, seq := range tt.seqs {
+				if err := w.write(seq, []byte("frame")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// simulate a crash by flushing what was written without finishing the segment
+			if w.bw != nil {
+				if err := w.bw.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				if err := w.gz.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				w.f.Close()
+			}
+
+			if _, err := newSegmentWriter(dir, 100); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(filepath.Join(dir, partialSegmentName)); !os.IsNotExist(err) {
+				t.Errorf("partial segment still exists: %v", err)
+			}
+
+			segs, err := listSegments(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(segs) != len(tt.want) {
+				t.Fatalf("got %d segments, want %d", len(segs), len(tt.want))
+			}
+			for i, seg := range segs {
+				if seg.firstSeq != tt.want[i][0] || seg.lastSeq != tt.want[i][1] {
+					t.Errorf("segment %d covers %d-%d, want %d-%d", i, seg.firstSeq, seg.lastSeq, tt.want[i][0], tt.want[i][1])
+				}
+			}
+		})
+	}
+}
+
+func encodeFrame(t *testing.T, header events.EventHeader, body cbg.CBORMarshaler) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := header.MarshalCBOR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := body.MarshalCBOR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFrameSeq(t *testing.T) {
+	c, err := cid.Decode("bafyreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := &atproto.SyncSubscribeRepos_Commit{
+		Seq:    1234,
+		Repo:   "did:plc:example",
+		Rev:    "3kabc",
+		Commit: lexutil.LexLink(c),
+		Blocks: bytes.Repeat([]byte{0xff}, 32),
+		Time:   "2024-01-01T00:00:00Z",
+	}
+
+	tests := []struct {
+		name       string
+		frame      []byte
+		want       int64
+		wantErr    bool
+		decodeable bool
+	}{
+		{
+			name:       "commit",
+			frame:      encodeFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#commit"}, commit),
+			want:       1234,
+			decodeable: true,
+		},
+		{
+			name:       "identity",
+			frame:      encodeFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#identity"}, &atproto.SyncSubscribeRepos_Identity{Seq: 99, Did: "did:plc:example", Time: "2024-01-01T00:00:00Z"}),
+			want:       99,
+			decodeable: true,
+		},
+		{
+			name:  "unknown message type still has its seq read",
+			frame: encodeFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#somethingNew"}, commit),
+			want:  1234,
+		},
+		{
+			name:       "info frame has no seq",
+			frame:      encodeFrame(t, events.EventHeader{Op: events.EvtKindMessage, MsgType: "#info"}, &atproto.SyncSubscribeRepos_Info{Name: "OutdatedCursor"}),
+			want:       0,
+			decodeable: true,
+		},
+		{
+			name:       "error frame has no seq",
+			frame:      encodeFrame(t, events.EventHeader{Op: events.EvtKindErrorFrame}, &events.ErrorFrame{Error: "FutureCursor"}),
+			want:       0,
+			decodeable: true,
+		},
+		{
+			name:    "garbage",
+			frame:   []byte{0xff, 0x00},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := frameSeq(tt.frame)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("frameSeq err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("frameSeq = %d, want %d", got, tt.want)
+			}
+
+			// frameSeq must agree with the full decode for every frame the full decode understands
+			_, seq, err := decodeFrame(tt.frame)
+			if tt.decodeable {
+				if err != nil {
+					t.Fatalf("decodeFrame: %v", err)
+				}
+				if seq != got {
+					t.Errorf("decodeFrame seq = %d, frameSeq = %d", seq, got)
+				}
+			} else if err == nil {
+				t.Errorf("decodeFrame unexpectedly decoded the frame")
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package photocopy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/bluesky-social/indigo/repo"
+)
+
+const tooBigWorkers = 4
+
+type tooBigJob struct {
+	did   string
+	since string
+	rev   string
+	time  string
+	seq   int64
+	ops   []*atproto.SyncSubscribeRepos_RepoOp
+	// marks the seq done in the relay's cursor once the commit has been recovered
+	done func()
+}
+
+// enqueueTooBig hands the commit to the recovery workers, returning false if we shut down first. The
+// seq stays in flight in the relay's cursor until a worker has finished with it.
+func (p *Photocopy) enqueueTooBig(ctx context.Context, rl *relay, evt *atproto.SyncSubscribeRepos_Commit) bool {
+	job := tooBigJob{
+		did:  evt.Repo,
+		rev:  evt.Rev,
+		time: evt.Time,
+		seq:  evt.Seq,
+		ops:  evt.Ops,
+		done: func() { rl.cursor.done(evt.Seq) },
+	}
+	if evt.Since != nil {
+		job.since = *evt.Since
+	}
+
+	p.tooBigInflight.Add(1)
+	select {
+	case p.tooBigQueue <- job:
+		return true
+	case <-ctx.Done():
+		p.tooBigInflight.Add(-1)
+		return false
+	}
+}
+
+func (p *Photocopy) runTooBigRecoverer(ctx context.Context) {
+	for range tooBigWorkers {
+		p.background.Add(1)
+		go func() {
+			defer p.background.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-p.tooBigQueue:
+					skipped, err := p.recoverTooBig(ctx, job)
+					p.tooBigInflight.Add(-1)
+					if ctx.Err() != nil {
+						// cut short by shutdown, so leave the seq in flight and pick the commit up again
+						// on restart
+						continue
+					}
+					job.done()
+
+					switch {
+					case err != nil:
+						p.logger.Error("failed to recover too big commit", "did", job.did, "seq", job.seq, "error", err)
+						tooBigCommits.WithLabelValues("unrecoverable").Inc()
+					case skipped > 0 && skipped == len(job.ops):
+						p.logger.Warn("none of the too big commit's ops could be recovered", "did", job.did, "seq", job.seq, "ops", skipped)
+						tooBigCommits.WithLabelValues("skipped").Inc()
+					case skipped > 0:
+						p.logger.Warn("some of the too big commit's ops could not be recovered", "did", job.did, "seq", job.seq, "skipped", skipped, "ops", len(job.ops))
+						tooBigCommits.WithLabelValues("partial").Inc()
+					default:
+						tooBigCommits.WithLabelValues("recovered").Inc()
+					}
+				}
+			}
+		}()
+	}
+}
+
+// recoverTooBig fetches the commit's repo from the pds and ingests it, returning how many of the job's
+// ops couldn't be ingested from what was fetched.
+func (p *Photocopy) recoverTooBig(ctx context.Context, job tooBigJob) (int, error) {
+	did, err := syntax.ParseDID(job.did)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse did: %w", err)
+	}
+
+	ident, err := p.dir.LookupDID(ctx, did)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve did: %w", err)
+	}
+
+	service := ident.PDSEndpoint()
+	if service == "" {
+		return 0, fmt.Errorf("no pds endpoint for did")
+	}
+
+	// without an op list there's nothing to look up, so we need the full repo to walk
+	since := job.since
+	if len(job.ops) == 0 {
+		since = ""
+	}
+
+	p.repoDownloader.getRateLimiter(service).Take()
+
+	b, err := p.repoDownloader.downloadRepo(service, job.did, since)
+	if (err != nil || b == nil) && since != "" {
+		// not every pds supports since, so fall back to fetching the whole thing
+		p.logger.Info("getRepo with since failed, fetching full repo", "did", job.did, "since", since, "error", err)
+		b, err = p.repoDownloader.downloadRepo(service, job.did, "")
+	}
+	if err != nil {
+		return 0, err
+	}
+	if b == nil {
+		return 0, fmt.Errorf("repo not found on %s", service)
+	}
+
+	if len(job.ops) == 0 {
+		return 0, p.processRepo(ctx, b, job.did)
+	}
+
+	r, err := repo.ReadRepoFromCar(ctx, bytes.NewReader(b))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read fetched repo: %w", err)
+	}
+
+	return p.processOps(ctx, r, did, job.ops, job.time, job.rev, job.seq), nil
+}
@@ -0,0 +1,130 @@
+package photocopy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/araddon/dateparse"
+	"github.com/bluesky-social/indigo/api/atproto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	atproto_repo "github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/haileyok/photocopy/models"
+)
+
+// verifyCommit checks the commit signature against the account's current signing key, and that the ops
+// in the event agree with the MST diff carried in the blocks. Failures are written to the
+// verification_failure table and the commit is not ingested. When the account's identity can't be
+// resolved for reasons that may pass, like the plc directory being unreachable, the lookup is retried
+// rather than recorded as a failure, and an error is only returned if ctx is cancelled while waiting.
+func (p *Photocopy) verifyCommit(ctx context.Context, evt *atproto.SyncSubscribeRepos_Commit) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		err := p.verifyCommitSignature(ctx, evt)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if !isTransientIdentityError(err) {
+			p.recordVerificationFailure(ctx, evt, "signature", err)
+			return false, nil
+		}
+
+		wait := reconnectBackoff(attempt)
+		p.logger.Warn("could not resolve identity to verify commit, retrying", "did", evt.Repo, "seq", evt.Seq, "attempt", attempt+1, "wait", wait, "error", err)
+		verificationRetries.Inc()
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+
+	if _, err := atproto_repo.VerifyCommitMessage(ctx, evt); err != nil {
+		p.recordVerificationFailure(ctx, evt, "mst", err)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// isTransientIdentityError reports whether an identity lookup failed for a reason that may pass, as
+// opposed to the did not existing or its document having no signing key.
+func isTransientIdentityError(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, identity.ErrDIDResolutionFailed) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
+}
+
+func (p *Photocopy) verifyCommitSignature(ctx context.Context, evt *atproto.SyncSubscribeRepos_Commit) error {
+	commit, _, err := atproto_repo.LoadRepoFromCAR(ctx, bytes.NewReader(evt.Blocks))
+	if err != nil {
+		return err
+	}
+	if err := commit.VerifyStructure(); err != nil {
+		return err
+	}
+	did, err := syntax.ParseDID(commit.DID)
+	if err != nil {
+		return err
+	}
+
+	err = p.verifyCommitAgainstIdentity(ctx, commit, did)
+	if err == nil || isTransientIdentityError(err) {
+		return err
+	}
+
+	// the cached identity may be stale if the account just rotated its signing key, so try once more
+	// against a fresh resolution before calling it a failure
+	if perr := p.dir.Purge(ctx, did.AtIdentifier()); perr != nil {
+		return err
+	}
+
+	return p.verifyCommitAgainstIdentity(ctx, commit, did)
+}
+
+func (p *Photocopy) verifyCommitAgainstIdentity(ctx context.Context, commit *atproto_repo.Commit, did syntax.DID) error {
+	ident, err := p.dir.LookupDID(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	pubkey, err := ident.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	return commit.VerifySignature(pubkey)
+}
+
+func (p *Photocopy) recordVerificationFailure(ctx context.Context, evt *atproto.SyncSubscribeRepos_Commit, reason string, verr error) {
+	p.logger.Warn("commit failed verification", "did", evt.Repo, "seq", evt.Seq, "reason", reason, "error", verr)
+	verificationFailures.WithLabelValues(reason).Inc()
+
+	t, err := dateparse.ParseAny(evt.Time)
+	if err != nil {
+		t = time.Now()
+	}
+
+	vf := models.VerificationFailure{
+		Did:       evt.Repo,
+		Seq:       evt.Seq,
+		Rev:       evt.Rev,
+		Commit:    evt.Commit.String(),
+		Reason:    reason,
+		Error:     verr.Error(),
+		Blocks:    string(evt.Blocks),
+		Time:      t,
+		IndexedAt: time.Now(),
+	}
+
+	if err := p.inserters.verificationFailuresInserter.Insert(ctx, vf); err != nil {
+		p.logger.Error("error inserting verification failure", "error", err)
+	}
+}
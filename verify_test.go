@@ -0,0 +1,35 @@
+package photocopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/identity"
+)
+
+func TestIsTransientIdentityError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"plc directory unreachable", fmt.Errorf("%w: PLC directory lookup: %w", identity.ErrDIDResolutionFailed, errors.New("connection refused")), true},
+		{"plc directory error status", fmt.Errorf("%w: PLC directory status 503", identity.ErrDIDResolutionFailed), true},
+		{"lookup timed out", fmt.Errorf("lookup: %w", context.DeadlineExceeded), true},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("no route to host")}, true},
+		{"did not found", fmt.Errorf("%w: PLC directory 404", identity.ErrDIDNotFound), false},
+		{"no signing key", identity.ErrKeyNotDeclared, false},
+		{"bad signature", errors.New("crypto/k256: invalid signature"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientIdentityError(tt.err); got != tt.want {
+				t.Errorf("isTransientIdentityError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}